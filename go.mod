@@ -0,0 +1,13 @@
+module github.com/caltechlibrary/oclc_reconciliation
+
+go 1.22
+
+require (
+	github.com/caltechlibrary/datatools v0.0.25
+	go.etcd.io/bbolt v1.3.11
+)
+
+require (
+	github.com/dexyk/stringosim v0.0.0-20170922105913-9d0b3e91a842 // indirect
+	golang.org/x/sys v0.4.0 // indirect
+)
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"sort"
+)
+
+const (
+	// Candidate statuses set by Assign
+	statusLinked    = "linked"
+	statusAlternate = "alternate"
+)
+
+// passRank orders passes from most to least trustworthy
+var passRank = map[string]int{
+	passForced:      0,
+	passExact:       1,
+	passTrimmed:     2,
+	passLevenshtein: 3,
+}
+
+// reviewed reports if a reviewer accepted or forced the candidate
+func reviewed(c *Candidate) bool {
+	return c.Verdict == verdictAccept || c.Verdict == verdictForce
+}
+
+// Assign reduces the many-to-many candidates of results to a one-to-one
+// linkage. Pairs are taken greedily, reviewed pairs first then best pass
+// then highest score then in the order they were found, and a pair is
+// linked only when neither its target nor its source is linked already.
+// Pairs losing such a conflict are kept as alternates. Returns the
+// number of links.
+func Assign(results []*Result) int {
+	type pair struct {
+		res *Result
+		c   *Candidate
+		no  int
+	}
+	pairs := []*pair{}
+	for _, res := range results {
+		for _, c := range res.Candidates {
+			pairs = append(pairs, &pair{res: res, c: c, no: len(pairs)})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		a, b := pairs[i], pairs[j]
+		if reviewed(a.c) != reviewed(b.c) {
+			return reviewed(a.c)
+		}
+		if passRank[a.c.Pass] != passRank[b.c.Pass] {
+			return passRank[a.c.Pass] < passRank[b.c.Pass]
+		}
+		if a.c.Score != b.c.Score {
+			return a.c.Score > b.c.Score
+		}
+		return a.no < b.no
+	})
+	linkedTargets := map[*Result]bool{}
+	linkedSources := map[*Record]bool{}
+	cnt := 0
+	for _, p := range pairs {
+		if linkedTargets[p.res] || linkedSources[p.c.source] {
+			p.c.Status = statusAlternate
+			continue
+		}
+		p.c.Status = statusLinked
+		linkedTargets[p.res] = true
+		linkedSources[p.c.source] = true
+		cnt++
+	}
+	return cnt
+}
+
+// splitAssigned separates the linked candidate of an assigned result
+// from its alternates, either may come back nil
+func splitAssigned(res *Result) (*Result, *Result) {
+	var linked, alternates *Result
+	for _, c := range res.Candidates {
+		switch c.Status {
+		case statusLinked:
+			linked = &Result{Target: res.Target, Candidates: []*Candidate{c}}
+		case statusAlternate:
+			if alternates == nil {
+				alternates = &Result{Target: res.Target, Candidates: []*Candidate{}}
+			}
+			alternates.Candidates = append(alternates.Candidates, c)
+		}
+	}
+	return linked, alternates
+}
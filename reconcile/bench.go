@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"runtime"
+	"text/tabwriter"
+	"time"
+)
+
+// DatasetBench measures scanning a dataset as a run does
+type DatasetBench struct {
+	Source      string  `json:"source"`
+	OCLC        int     `json:"oclc"`
+	Tind        int     `json:"tind"`
+	LoadSeconds float64 `json:"load_seconds"`
+	// RecordsBytes is the heap the records hold once loaded
+	RecordsBytes uint64  `json:"records_bytes"`
+	Targets      int     `json:"targets"`
+	Levenshtein  int     `json:"levenshtein_targets"`
+	Compared     int64   `json:"pairs_compared"`
+	ScanSeconds  float64 `json:"scan_seconds"`
+	PerSecond    float64 `json:"comparisons_per_second"`
+	AllocBytes   uint64  `json:"scan_alloc_bytes"`
+	// SysBytes is the memory the program got from the system
+	SysBytes uint64 `json:"sys_bytes"`
+	// EstimateSeconds is a whole run's scan time, every OCLC record
+	// taking as long as those scanned did on average
+	EstimateSeconds float64 `json:"estimate_seconds"`
+}
+
+// BenchReport is what "reconcile bench" reports
+type BenchReport struct {
+	GoVersion string        `json:"go_version"`
+	CPUs      int           `json:"cpus"`
+	Dataset   *DatasetBench `json:"dataset"`
+}
+
+// benchDataset loads the exports (or generates n records) and scans up
+// to targets OCLC records against all the TIND records as a run would
+func benchDataset(ctx context.Context, exports *exportOptions, synthetic bool, n, targets int) (*DatasetBench, error) {
+	db := new(DatasetBench)
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	startT := time.Now()
+	var oclc, tind []*Record
+	if synthetic == true {
+		syn := Generate(&GenerateOptions{Records: n, Matched: 0.8, TindOnly: 0.2, Editions: 0.05, Typos: 0.1,
+			ISBNFormats: 0.1, MissingSubtitles: 0.1, PublisherVariants: 0.1, Seed: 1})
+		oclc, tind = syn.OCLC, syn.Tind
+		db.Source = fmt.Sprintf("synthetic, %d OCLC records", n)
+	} else {
+		var err error
+		if oclc, tind, err = exports.load(); err != nil {
+			return nil, err
+		}
+		db.Source = exports.oclcFName + " " + exports.tindFName
+		if exports.storeFName != "" {
+			db.Source = exports.storeFName
+		}
+	}
+	db.LoadSeconds = time.Since(startT).Seconds()
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	if after.HeapAlloc > before.HeapAlloc {
+		db.RecordsBytes = after.HeapAlloc - before.HeapAlloc
+	}
+	db.OCLC, db.Tind = len(oclc), len(tind)
+	if len(oclc) == 0 || len(tind) == 0 {
+		return nil, fmt.Errorf("read %d OCLC and %d TIND records, there is nothing to scan", len(oclc), len(tind))
+	}
+	if targets > len(oclc) || targets < 1 {
+		targets = len(oclc)
+	}
+	db.Targets = targets
+
+	runtime.ReadMemStats(&before)
+	startT = time.Now()
+	for _, target := range oclc[:targets] {
+		matched, err := Scan(ctx, target, tind, false)
+		if err != nil {
+			return nil, err
+		}
+		db.Compared += int64(len(tind))
+		if len(matched) == 0 {
+			if _, err := Scan(ctx, target, tind, true); err != nil {
+				return nil, err
+			}
+			db.Compared += int64(len(tind))
+			db.Levenshtein++
+		}
+	}
+	db.ScanSeconds = time.Since(startT).Seconds()
+	runtime.ReadMemStats(&after)
+	db.AllocBytes = after.TotalAlloc - before.TotalAlloc
+	db.SysBytes = after.Sys
+	if db.ScanSeconds > 0 {
+		db.PerSecond = float64(db.Compared) / db.ScanSeconds
+		db.EstimateSeconds = db.ScanSeconds * float64(len(oclc)) / float64(targets)
+	}
+	return db, nil
+}
+
+// megabytes formats a byte count as MiB
+func megabytes(n uint64) string {
+	return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+}
+
+// WriteText writes the report as aligned plain text
+func (br *BenchReport) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "%s, %d CPUs\n", br.GoVersion, br.CPUs)
+	if db := br.Dataset; db != nil {
+		fmt.Fprintf(tw, "\nDataset\t%s\n", db.Source)
+		fmt.Fprintf(tw, "Records\t%d OCLC, %d TIND\n", db.OCLC, db.Tind)
+		fmt.Fprintf(tw, "Load\t%.2fs, %s held\n", db.LoadSeconds, megabytes(db.RecordsBytes))
+		fmt.Fprintf(tw, "Scanned\t%d OCLC records, %d through the Levenshtein pass\n", db.Targets, db.Levenshtein)
+		fmt.Fprintf(tw, "Pairs compared\t%d in %.2fs\n", db.Compared, db.ScanSeconds)
+		fmt.Fprintf(tw, "Comparisons/sec\t%.0f\n", db.PerSecond)
+		fmt.Fprintf(tw, "Allocated scanning\t%s\n", megabytes(db.AllocBytes))
+		fmt.Fprintf(tw, "Memory from system\t%s\n", megabytes(db.SysBytes))
+		fmt.Fprintf(tw, "Estimated full scan\t%s\n", time.Duration(db.EstimateSeconds*float64(time.Second)).Round(time.Second).String())
+	}
+	return tw.Flush()
+}
+
+// bench measures the matcher's throughput over a dataset, "reconcile
+// bench". The benchmarks of its parts are go test benchmarks.
+func bench(args []string) {
+	var (
+		n           int
+		targets     int
+		format      string
+		outFName    string
+		configFName string
+	)
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	exports := addExportFlags(fs)
+	fs.IntVar(&n, "n", 5000, "OCLC records to generate when no exports are given")
+	fs.IntVar(&targets, "targets", 500, "OCLC records to scan in the dataset benchmark, 0 for all")
+	fs.StringVar(&format, "format", "text", "output format, text or json")
+	fs.StringVar(&outFName, "o", "", "write the report to this file instead of stdout")
+	fs.StringVar(&configFName, "config", "", "matcher config written by reconcile tune (default the built in matcher)")
+	logOpts := addLogFlags(fs)
+	fs.Parse(args)
+	if err := logOpts.setup(); err != nil {
+		log.Fatal(err)
+	}
+	if format != "text" && format != "json" {
+		log.Fatalf("unknown format %q, use text or json", format)
+	}
+	if configFName != "" {
+		mc, err := LoadMatchConfig(configFName)
+		if err != nil {
+			log.Fatalf("Can't read %s, %s", configFName, err)
+		}
+		matcher = mc
+	}
+	// Without exports named on the command line the dataset is generated
+	synthetic := true
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "oclc" || f.Name == "tind" || f.Name == "store" {
+			synthetic = false
+		}
+	})
+
+	br := &BenchReport{GoVersion: runtime.Version(), CPUs: runtime.NumCPU()}
+	ctx, stop := interruptible()
+	defer stop()
+	slog.Info("scanning dataset", "synthetic", synthetic, "targets", targets)
+	var err error
+	if br.Dataset, err = benchDataset(ctx, exports, synthetic, n, targets); err != nil {
+		log.Fatal(err)
+	}
+
+	out := os.Stdout
+	if outFName != "" {
+		if out, err = os.Create(outFName); err != nil {
+			log.Fatalf("Can't create %s, %s", outFName, err)
+		}
+		defer out.Close()
+	}
+	if format == "json" {
+		src, err := json.MarshalIndent(br, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Fprintf(out, "%s\n", src)
+	} else if err := br.WriteText(out); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"testing"
+)
+
+// benchSizes are the TIND record counts the scans are timed against
+var benchSizes = []int{100, 1000, 10000}
+
+var benchSynthetic *Synthetic
+
+// benchRecords returns generated records with a TIND copy of every OCLC
+// record, enough for the largest of benchSizes
+func benchRecords(b *testing.B) *Synthetic {
+	if benchSynthetic == nil {
+		largest := benchSizes[len(benchSizes)-1]
+		benchSynthetic = Generate(&GenerateOptions{Records: largest, Matched: 1, Typos: 0.1, ISBNFormats: 0.1,
+			MissingSubtitles: 0.1, PublisherVariants: 0.1, Seed: 1})
+		if len(benchSynthetic.Tind) < largest {
+			b.Fatalf("generated %d TIND records, wanted %d", len(benchSynthetic.Tind), largest)
+		}
+	}
+	return benchSynthetic
+}
+
+// reportRate reports the units of work done per second, work being the
+// units one op does
+func reportRate(b *testing.B, work int, unit string) {
+	if sec := b.Elapsed().Seconds(); sec > 0 {
+		b.ReportMetric(float64(work)*float64(b.N)/sec, unit)
+	}
+}
+
+// benchCSV returns the first n OCLC records as a CSV export
+func benchCSV(b *testing.B, n int) []byte {
+	buf := new(bytes.Buffer)
+	w := csv.NewWriter(buf)
+	w.Write(oclcColumns)
+	for _, rec := range benchRecords(b).OCLC[:n] {
+		row := []string{}
+		for _, cName := range oclcColumns {
+			row = append(row, rec.Field(cName))
+		}
+		w.Write(row)
+	}
+	w.Flush()
+	return buf.Bytes()
+}
+
+func BenchmarkMkRecords(b *testing.B) {
+	src := benchCSV(b, 1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := mkRecords(src, oclcColumns); err != nil {
+			b.Fatal(err)
+		}
+	}
+	reportRate(b, 1000, "records/s")
+}
+
+func BenchmarkRowToRecord(b *testing.B) {
+	rows, err := mkTable(benchCSV(b, 1000))
+	if err != nil {
+		b.Fatal(err)
+	}
+	rows = rows[1:]
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, row := range rows {
+			RowToRecord(oclcColumns, row)
+		}
+	}
+	reportRate(b, len(rows), "rows/s")
+}
+
+func BenchmarkCleanMARCValue(b *testing.B) {
+	values := [][2]string{
+		{"title", "The nature of light /"},
+		{"isbn", "0123456789 (pbk.) :"},
+		{"issn", "0012-3456 ;"},
+		{"oclc", "ocm00012345"},
+		{"year", "c1985."},
+		{"publisher", "Addison-Wesley,"},
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for _, v := range values {
+			cleanMARCValue(v[0], v[1])
+		}
+	}
+	reportRate(b, len(values), "values/s")
+}
+
+func BenchmarkTitleSimilarity(b *testing.B) {
+	syn := benchRecords(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j, rec := range syn.Tind[:1000] {
+			titleSimilarity(syn.OCLC[j].Title, rec.Title)
+		}
+	}
+	reportRate(b, 1000, "pairs/s")
+}
+
+func BenchmarkScore(b *testing.B) {
+	syn := benchRecords(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j, rec := range syn.Tind[:1000] {
+			Score(syn.OCLC[j], rec)
+		}
+	}
+	reportRate(b, 1000, "pairs/s")
+}
+
+// BenchmarkScan times each pass of one OCLC record against each of
+// benchSizes TIND records. levenshtein_worst is a Levenshtein pass where
+// every pair agrees well enough for the titles to be compared.
+func BenchmarkScan(b *testing.B) {
+	syn := benchRecords(b)
+	ctx := context.Background()
+	target := syn.OCLC[0]
+	for _, size := range benchSizes {
+		sources := syn.Tind[:size]
+		near := make([]*Record, size)
+		for i, rec := range sources {
+			near[i] = new(Record)
+			*near[i] = *target
+			near[i].Title = rec.Title
+		}
+		for _, pass := range []struct {
+			name            string
+			sources         []*Record
+			withLevenshtein bool
+		}{
+			{"exact", sources, false},
+			{"levenshtein", sources, true},
+			{"levenshtein_worst", near, true},
+		} {
+			b.Run(fmt.Sprintf("%s/%d", pass.name, size), func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if _, err := Scan(ctx, target, pass.sources, pass.withLevenshtein); err != nil {
+						b.Fatal(err)
+					}
+				}
+				reportRate(b, size, "pairs/s")
+			})
+		}
+	}
+}
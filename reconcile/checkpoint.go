@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// Checkpoint is the state of a run saved so an interrupted run can be
+// resumed. Output files are truncated back to Offsets on resume so
+// rows written after the checkpoint are neither lost nor duplicated.
+// The lists growing with the run are appended to a JSON lines sidecar
+// (see checkpointEntry) as they grow, read back up to LinksOffset.
+type Checkpoint struct {
+	OCLCCount    int              `json:"oclc_count"`
+	TindCount    int              `json:"tind_count"`
+	Pass         int              `json:"pass"`
+	Position     int              `json:"position"`
+	LastID       string           `json:"last_id"`
+	MatchedCnt   int              `json:"matched_count"`
+	UnmatchedCnt int              `json:"unmatched_count"`
+	Unmatched    []int            `json:"-"`
+	Missing      []int            `json:"-"`
+	MatchedTind  []int            `json:"-"`
+	Links        []*Link          `json:"-"`
+	Matches      []*targetMatch   `json:"-"`
+	LinksOffset  int64            `json:"links_offset"`
+	Offsets      map[string]int64 `json:"offsets"`
+}
+
+// targetMatch records the passes of the candidates an OCLC record, by
+// its position, was output with. Reports tally these rather than the
+// links, which leave out records without an id.
+type targetMatch struct {
+	No     int      `json:"no"`
+	Passes []string `json:"passes"`
+}
+
+// checkpointEntry is a line of the checkpoint's sidecar, each holds one
+// of a link found, an OCLC record matched, the position of a TIND record
+// matched or the position of an OCLC record left unmatched by the exact
+// or the Levenshtein pass
+type checkpointEntry struct {
+	Link        *Link        `json:"link,omitempty"`
+	Match       *targetMatch `json:"match,omitempty"`
+	MatchedTind *int         `json:"matched_tind,omitempty"`
+	Unmatched   *int         `json:"unmatched,omitempty"`
+	Missing     *int         `json:"missing,omitempty"`
+}
+
+// sidecarName returns the name of the sidecar of checkpoint fName
+func sidecarName(fName string) string {
+	return fName + ".jsonl"
+}
+
+// LoadCheckpoint reads a checkpoint written by a previous run
+func LoadCheckpoint(fName string) (*Checkpoint, error) {
+	src, err := ioutil.ReadFile(fName)
+	if err != nil {
+		return nil, err
+	}
+	st := new(Checkpoint)
+	if err := json.Unmarshal(src, st); err != nil {
+		return nil, fmt.Errorf("%s, %s", fName, err)
+	}
+	if st.LinksOffset == 0 {
+		return st, nil
+	}
+	// Lines past LinksOffset were written after the checkpoint
+	fp, err := os.Open(sidecarName(fName))
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	rd := bufio.NewReader(io.LimitReader(fp, st.LinksOffset))
+	read := int64(0)
+	for i := 1; ; i++ {
+		line, err := rd.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%s is shorter than the checkpoint", sidecarName(fName))
+		}
+		read += int64(len(line))
+		e := new(checkpointEntry)
+		if err := json.Unmarshal(line, e); err != nil {
+			return nil, fmt.Errorf("%s line %d, %s", sidecarName(fName), i, err)
+		}
+		switch {
+		case e.Link != nil:
+			st.Links = append(st.Links, e.Link)
+		case e.Match != nil:
+			st.Matches = append(st.Matches, e.Match)
+		case e.MatchedTind != nil:
+			st.MatchedTind = append(st.MatchedTind, *e.MatchedTind)
+		case e.Unmatched != nil:
+			st.Unmatched = append(st.Unmatched, *e.Unmatched)
+		case e.Missing != nil:
+			st.Missing = append(st.Missing, *e.Missing)
+		}
+	}
+	if read != st.LinksOffset {
+		return nil, fmt.Errorf("%s is shorter than the checkpoint", sidecarName(fName))
+	}
+	return st, nil
+}
+
+// lastTarget returns the last OCLC record processed before the
+// checkpoint or nil at the start of a phase
+func (r *run) lastTarget() *Record {
+	nos := r.targets(r.state.Pass)
+	if r.state.Position == 0 || r.state.Position > len(nos) {
+		return nil
+	}
+	return r.oclc[nos[r.state.Position-1]]
+}
+
+// checkResume makes sure a loaded checkpoint fits the exports read
+func (r *run) checkResume() error {
+	st := r.state
+	if st.OCLCCount != len(r.oclc) || st.TindCount != len(r.tind) {
+		return fmt.Errorf("checkpoint is for %d OCLC and %d TIND records, read %d and %d",
+			st.OCLCCount, st.TindCount, len(r.oclc), len(r.tind))
+	}
+	if rec := r.lastTarget(); rec != nil && rec.OCLC != st.LastID {
+		return fmt.Errorf("checkpoint stopped at OCLC %q, the export has %q there", st.LastID, rec.OCLC)
+	}
+	return nil
+}
+
+// appendSidecar appends what the run found since the last checkpoint
+// to the sidecar and notes its new size in the state
+func (r *run) appendSidecar() error {
+	st := r.state
+	fp, err := os.OpenFile(sidecarName(r.checkpointFName), os.O_RDWR|os.O_CREATE, 0664)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	// A sidecar longer than the checkpoint holds lines of a run which
+	// stopped before saving it
+	if err := fp.Truncate(st.LinksOffset); err != nil {
+		return err
+	}
+	if _, err := fp.Seek(st.LinksOffset, io.SeekStart); err != nil {
+		return err
+	}
+	buf := bufio.NewWriter(fp)
+	enc := json.NewEncoder(buf)
+	entries := []*checkpointEntry{}
+	for _, l := range r.found.pairs[r.saved.links:] {
+		entries = append(entries, &checkpointEntry{Link: l})
+	}
+	for _, m := range st.Matches[r.saved.matches:] {
+		entries = append(entries, &checkpointEntry{Match: m})
+	}
+	for i := range r.newTind {
+		entries = append(entries, &checkpointEntry{MatchedTind: &r.newTind[i]})
+	}
+	for i := range st.Unmatched[r.saved.unmatched:] {
+		entries = append(entries, &checkpointEntry{Unmatched: &st.Unmatched[r.saved.unmatched+i]})
+	}
+	for i := range st.Missing[r.saved.missing:] {
+		entries = append(entries, &checkpointEntry{Missing: &st.Missing[r.saved.missing+i]})
+	}
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+	if err := fp.Sync(); err != nil {
+		return err
+	}
+	if st.LinksOffset, err = fp.Seek(0, io.SeekCurrent); err != nil {
+		return err
+	}
+	r.saved.links, r.saved.matches = len(r.found.pairs), len(st.Matches)
+	r.saved.unmatched, r.saved.missing = len(st.Unmatched), len(st.Missing)
+	r.newTind = r.newTind[:0]
+	return nil
+}
+
+// checkpoint flushes the outputs and saves the state of the run, only
+// what was found since the last checkpoint is written
+func (r *run) checkpoint() error {
+	if err := r.flushStore(); err != nil {
+		return err
+	}
+	st := r.state
+	st.OCLCCount = len(r.oclc)
+	st.TindCount = len(r.tind)
+	st.LastID = ""
+	if rec := r.lastTarget(); rec != nil {
+		st.LastID = rec.OCLC
+	}
+	if err := r.appendSidecar(); err != nil {
+		return err
+	}
+	offsets, err := r.out.Offsets()
+	if err != nil {
+		return err
+	}
+	st.Offsets = offsets
+	src, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	// Write then rename so a crash never leaves a half written checkpoint
+	tmpName := r.checkpointFName + ".tmp"
+	if err := ioutil.WriteFile(tmpName, src, 0664); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, r.checkpointFName)
+}
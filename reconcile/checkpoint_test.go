@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestCheckpointSidecar(t *testing.T) {
+	oclc, err := loadExport("oclc", "testdata/oclc.csv", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tind, err := loadExport("tind", "testdata/tind.csv", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dName := t.TempDir()
+	outFName := filepath.Join(dName, "out.csv")
+	out, err := openOutputs("csv", outFName, outFName, outFName, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newRun(oclc, tind, out, new(Checkpoint))
+	r.checkpointFName = filepath.Join(dName, "run.json")
+	r.checkpointEvery = 1
+	if err := r.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	out.Close()
+
+	st, err := LoadCheckpoint(r.checkpointFName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Pass != phaseDone {
+		t.Errorf("checkpoint left in phase %d", st.Pass)
+	}
+	if reflect.DeepEqual(st.Links, r.found.pairs) == false {
+		t.Errorf("checkpoint holds %d links, the run found %d", len(st.Links), len(r.found.pairs))
+	}
+	if reflect.DeepEqual(st.Matches, r.state.Matches) == false {
+		t.Errorf("checkpoint holds %d matched OCLC records, the run matched %d", len(st.Matches), len(r.state.Matches))
+	}
+	matchedTind := []int{}
+	for no := range r.matchedTind {
+		matchedTind = append(matchedTind, no)
+	}
+	sort.Ints(matchedTind)
+	sort.Ints(st.MatchedTind)
+	if reflect.DeepEqual(st.MatchedTind, matchedTind) == false {
+		t.Errorf("checkpoint holds matched TIND %v, want %v", st.MatchedTind, matchedTind)
+	}
+	if reflect.DeepEqual(st.Unmatched, r.state.Unmatched) == false || reflect.DeepEqual(st.Missing, r.state.Missing) == false {
+		t.Errorf("checkpoint holds unmatched %v missing %v, want %v and %v", st.Unmatched, st.Missing, r.state.Unmatched, r.state.Missing)
+	}
+	if len(st.Links) == 0 || len(st.Unmatched) == 0 {
+		t.Fatalf("the test exports should give links and unmatched rows")
+	}
+	// Checkpointing every row must not write anything twice
+	src, err := ioutil.ReadFile(sidecarName(r.checkpointFName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.Count(src, []byte("\n"))
+	if want := len(st.Links) + len(st.Matches) + len(st.MatchedTind) + len(st.Unmatched) + len(st.Missing); lines != want {
+		t.Errorf("sidecar has %d lines, want %d", lines, want)
+	}
+
+	// Lines written after the checkpoint are ignored
+	fp, err := os.OpenFile(sidecarName(r.checkpointFName), os.O_APPEND|os.O_WRONLY, 0664)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp.WriteString("{\"link\":{\"oclc\":\"999\",\"tind\":\"9999\",\"pass\":\"exact\"}}\n{\"unma")
+	fp.Close()
+	again, err := LoadCheckpoint(r.checkpointFName)
+	if err != nil {
+		t.Fatalf("checkpoint with lines past its offset, %s", err)
+	}
+	if len(again.Links) != len(st.Links) {
+		t.Errorf("read %d links past the checkpoint", len(again.Links)-len(st.Links))
+	}
+	// and a sidecar cut short is an error
+	if err := os.Truncate(sidecarName(r.checkpointFName), st.LinksOffset-1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadCheckpoint(r.checkpointFName); err == nil {
+		t.Errorf("checkpoint loaded with its sidecar cut short")
+	}
+}
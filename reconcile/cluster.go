@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// unionFind is a disjoint set forest over the numbers 0 to n-1, unions
+// by size with path halving so long chains of links stay cheap
+type unionFind struct {
+	parent []int
+	size   []int
+}
+
+func newUnionFind(n int) *unionFind {
+	uf := &unionFind{parent: make([]int, n), size: make([]int, n)}
+	for i := range uf.parent {
+		uf.parent[i] = i
+		uf.size[i] = 1
+	}
+	return uf
+}
+
+// find returns the number standing for the set holding i
+func (uf *unionFind) find(i int) int {
+	for uf.parent[i] != i {
+		uf.parent[i] = uf.parent[uf.parent[i]]
+		i = uf.parent[i]
+	}
+	return i
+}
+
+// union joins the sets holding i and j, false if they were one already
+func (uf *unionFind) union(i, j int) bool {
+	i, j = uf.find(i), uf.find(j)
+	if i == j {
+		return false
+	}
+	if uf.size[i] < uf.size[j] {
+		i, j = j, i
+	}
+	uf.parent[j] = i
+	uf.size[i] += uf.size[j]
+	return true
+}
+
+// groups returns the sets, each in ascending order and the sets in the
+// order of their smallest member
+func (uf *unionFind) groups() [][]int {
+	index := map[int]int{}
+	groups := [][]int{}
+	for i := range uf.parent {
+		root := uf.find(i)
+		g, ok := index[root]
+		if ok == false {
+			g = len(groups)
+			index[root] = g
+			groups = append(groups, []int{})
+		}
+		groups[g] = append(groups[g], i)
+	}
+	return groups
+}
+
+const (
+	// The shapes of a component of the match graph
+	componentOneToOne   = "one_to_one"
+	componentOneToMany  = "one_to_many"
+	componentManyToOne  = "many_to_one"
+	componentManyToMany = "many_to_many"
+)
+
+// Component is a connected component of the bipartite graph of OCLC
+// and TIND records joined by the pairs a run found. A component with
+// several records on both sides is usually a multi-volume set or
+// editions confused with one another, best reviewed as a unit.
+type Component struct {
+	ID    string   `json:"component"`
+	Kind  string   `json:"kind"`
+	OCLC  []string `json:"oclc"`
+	Tind  []string `json:"tind"`
+	Links []*Link  `json:"links"`
+}
+
+// Components returns the connected components of the pairs found, in
+// the order their first pair was found
+func (l *links) Components() []*Component {
+	nodes := map[string]int{}
+	node := func(key string) int {
+		i, ok := nodes[key]
+		if ok == false {
+			i = len(nodes)
+			nodes[key] = i
+		}
+		return i
+	}
+	type edge struct {
+		link       *Link
+		oclc, tind int
+	}
+	edges := []*edge{}
+	seen := map[string]bool{}
+	for _, pair := range l.pairs {
+		key := pairKey(pair.OCLC, pair.Tind)
+		if seen[key] == true {
+			continue
+		}
+		seen[key] = true
+		edges = append(edges, &edge{link: pair, oclc: node("oclc\x1f" + pair.OCLC), tind: node("tind\x1f" + pair.Tind)})
+	}
+	uf := newUnionFind(len(nodes))
+	for _, e := range edges {
+		uf.union(e.oclc, e.tind)
+	}
+	byRoot := map[int]*Component{}
+	components := []*Component{}
+	inComponent := map[int]bool{}
+	for _, e := range edges {
+		root := uf.find(e.oclc)
+		c, ok := byRoot[root]
+		if ok == false {
+			c = &Component{ID: fmt.Sprintf("G%d", len(components)+1), OCLC: []string{}, Tind: []string{}, Links: []*Link{}}
+			byRoot[root] = c
+			components = append(components, c)
+		}
+		if inComponent[e.oclc] == false {
+			c.OCLC = append(c.OCLC, e.link.OCLC)
+			inComponent[e.oclc] = true
+		}
+		if inComponent[e.tind] == false {
+			c.Tind = append(c.Tind, e.link.Tind)
+			inComponent[e.tind] = true
+		}
+		c.Links = append(c.Links, e.link)
+	}
+	for _, c := range components {
+		switch {
+		case len(c.OCLC) == 1 && len(c.Tind) == 1:
+			c.Kind = componentOneToOne
+		case len(c.OCLC) == 1:
+			c.Kind = componentOneToMany
+		case len(c.Tind) == 1:
+			c.Kind = componentManyToOne
+		default:
+			c.Kind = componentManyToMany
+		}
+	}
+	return components
+}
+
+// WriteComponents writes the components to fName, JSON lines (a line
+// per component) for a .jsonl or .json name, otherwise CSV with a row
+// per pair
+func WriteComponents(fName string, components []*Component) error {
+	fp, err := os.Create(fName)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	switch strings.ToLower(path.Ext(fName)) {
+	case ".jsonl", ".json":
+		for _, c := range components {
+			src, err := json.Marshal(c)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(fp, "%s\n", src)
+		}
+		return fp.Close()
+	}
+	w := csv.NewWriter(fp)
+	w.Write([]string{"component", "kind", "oclc records", "tind records", "oclc", "tind", "pass", "status"})
+	for _, c := range components {
+		for _, link := range c.Links {
+			w.Write([]string{c.ID, c.Kind, strconv.Itoa(len(c.OCLC)), strconv.Itoa(len(c.Tind)), link.OCLC, link.Tind, link.Pass, link.Status})
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return fp.Close()
+}
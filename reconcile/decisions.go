@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"time"
+
+	// 3rd Party Packages
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// Review verdicts on an OCLC/TIND pair
+	verdictAccept = "accept"
+	verdictReject = "reject"
+	verdictForce  = "force"
+
+	// passForced marks a candidate linked by a reviewer which Match didn't find
+	passForced = "forced"
+)
+
+var decisionsBucket = []byte("decisions")
+
+// Decision is a reviewer's verdict on an OCLC/TIND pair
+type Decision struct {
+	OCLC    string `json:"oclc"`
+	Tind    string `json:"tind"`
+	Verdict string `json:"verdict"`
+	Updated string `json:"updated"`
+}
+
+// Decisions holds the latest verdict for each pair
+type Decisions struct {
+	byPair map[string]*Decision
+	// forced holds the forced TIND ids by OCLC number
+	forced map[string]map[string]bool
+}
+
+// NewDecisions returns an empty set of decisions
+func NewDecisions() *Decisions {
+	return &Decisions{byPair: map[string]*Decision{}, forced: map[string]map[string]bool{}}
+}
+
+func pairKey(oclcID, tindID string) string {
+	return oclcID + "\x1f" + tindID
+}
+
+// Set records d, replacing any earlier verdict on the pair
+func (ds *Decisions) Set(d *Decision) error {
+	switch d.Verdict {
+	case verdictAccept, verdictReject, verdictForce:
+	default:
+		return fmt.Errorf("unknown verdict %q for %s/%s", d.Verdict, d.OCLC, d.Tind)
+	}
+	ds.byPair[pairKey(d.OCLC, d.Tind)] = d
+	if ds.forced[d.OCLC] == nil {
+		ds.forced[d.OCLC] = map[string]bool{}
+	}
+	if d.Verdict == verdictForce {
+		ds.forced[d.OCLC][d.Tind] = true
+	} else {
+		delete(ds.forced[d.OCLC], d.Tind)
+	}
+	return nil
+}
+
+// Verdict returns the verdict on a pair or an empty string
+func (ds *Decisions) Verdict(oclcID, tindID string) string {
+	if d, ok := ds.byPair[pairKey(oclcID, tindID)]; ok == true {
+		return d.Verdict
+	}
+	return ""
+}
+
+// All returns every decision
+func (ds *Decisions) All() []*Decision {
+	all := []*Decision{}
+	for _, d := range ds.byPair {
+		all = append(all, d)
+	}
+	return all
+}
+
+// Forced returns the TIND ids a reviewer forced onto an OCLC record
+func (ds *Decisions) Forced(oclcID string) []string {
+	tindIDs := []string{}
+	for tindID := range ds.forced[oclcID] {
+		tindIDs = append(tindIDs, tindID)
+	}
+	sort.Strings(tindIDs)
+	return tindIDs
+}
+
+// LoadDecisions reads a decisions CSV file (oclc,tind,verdict,updated).
+// Rows are applied in order so a later verdict on a pair replaces an
+// earlier one. A missing file holds no decisions.
+func LoadDecisions(fName string) (*Decisions, error) {
+	ds := NewDecisions()
+	fp, err := os.Open(fName)
+	if os.IsNotExist(err) {
+		return ds, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	r := csv.NewReader(fp)
+	r.FieldsPerRecord = -1
+	for i := 0; ; i++ {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		//NOTE: We need to skip the header row
+		if i == 0 {
+			continue
+		}
+		if len(row) < 3 {
+			line, _ := r.FieldPos(0)
+			return nil, fmt.Errorf("%s line %d, expected oclc,tind,verdict but found %d columns", fName, line, len(row))
+		}
+		d := &Decision{OCLC: row[0], Tind: row[1], Verdict: row[2]}
+		if len(row) > 3 {
+			d.Updated = row[3]
+		}
+		if err := ds.Set(d); err != nil {
+			return nil, fmt.Errorf("%s row %d, %s", fName, i+1, err)
+		}
+	}
+	return ds, nil
+}
+
+// Merge adds the decisions in other, keeping whichever verdict on a pair
+// was updated last. A verdict without a readable time is the older, on a
+// tie other wins.
+func (ds *Decisions) Merge(other *Decisions) error {
+	for key, d := range other.byPair {
+		if held, ok := ds.byPair[key]; ok == true && updatedAt(held).After(updatedAt(d)) {
+			continue
+		}
+		if err := ds.Set(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updatedAt returns when d was made, the zero time when it isn't known
+func updatedAt(d *Decision) time.Time {
+	t, err := time.Parse(time.RFC3339, d.Updated)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// AppendDecision adds d to the decisions file fName, creating it when needed
+func AppendDecision(fName string, d *Decision) error {
+	if d.Updated == "" {
+		d.Updated = time.Now().Format(time.RFC3339)
+	}
+	_, err := os.Stat(fName)
+	isNew := os.IsNotExist(err)
+	fp, err := os.OpenFile(fName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0664)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	w := csv.NewWriter(fp)
+	if isNew {
+		w.Write([]string{"oclc", "tind", "verdict", "updated"})
+	}
+	w.Write([]string{d.OCLC, d.Tind, d.Verdict, d.Updated})
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return fp.Close()
+}
+
+// Apply brings the candidates Scan found for target in line with the
+// decisions. Rejected pairs are dropped, forced pairs are added without
+// consulting Match (in the first pass only, so a forced link keeps the
+// target out of the Levenshtein pass) and verdicts are noted on the
+// candidates kept. tindByID finds TIND records by id, a forced TIND id
+// the export doesn't hold is warned about and skipped.
+func (ds *Decisions) Apply(target *Record, matched []*Candidate, tindByID map[string][]*Record, withLevenshtein bool) []*Candidate {
+	kept := []*Candidate{}
+	seen := map[*Record]bool{}
+	for _, c := range matched {
+		verdict := ds.Verdict(target.OCLC, c.source.Tind)
+		if verdict == verdictReject {
+			continue
+		}
+		c.Verdict = verdict
+		seen[c.source] = true
+		kept = append(kept, c)
+	}
+	if withLevenshtein == false {
+		for _, tindID := range ds.Forced(target.OCLC) {
+			if len(tindByID[tindID]) == 0 {
+				slog.Warn("forced TIND id isn't in the TIND export, skipped", "oclc", target.OCLC, "tind", tindID)
+				continue
+			}
+			for _, source := range tindByID[tindID] {
+				if seen[source] {
+					continue
+				}
+				kept = append(kept, &Candidate{
+					Record:  Merge(target, source),
+					Pass:    passForced,
+					Score:   Score(target, source),
+					Fields:  Compare(target, source),
+					Verdict: verdictForce,
+					source:  source,
+				})
+			}
+		}
+	}
+	for _, c := range kept {
+		c.Record.MatchedCount = len(kept)
+	}
+	return kept
+}
+
+// SaveDecisions replaces the decisions held in the store
+func (s *Store) SaveDecisions(ds *Decisions) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := resetBucket(tx, decisionsBucket)
+		if err != nil {
+			return err
+		}
+		for key, d := range ds.byPair {
+			src, err := json.Marshal(d)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(key), src); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Decisions returns the decisions held in the store
+func (s *Store) Decisions() (*Decisions, error) {
+	ds := NewDecisions()
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(decisionsBucket)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			d := new(Decision)
+			if err := json.Unmarshal(v, d); err != nil {
+				return fmt.Errorf("decision %q, %s", k, err)
+			}
+			return ds.Set(d)
+		})
+	})
+	return ds, err
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApply(t *testing.T) {
+	target := testRecord()
+	target.OCLC = "101"
+	tindByID := map[string][]*Record{}
+	for _, id := range []string{"2001", "2002", "2003"} {
+		rec := testRecord()
+		rec.Tind = id
+		tindByID[id] = []*Record{rec}
+	}
+	scanned := func() []*Candidate {
+		return []*Candidate{
+			{Record: Merge(target, tindByID["2001"][0]), Pass: passExact, source: tindByID["2001"][0]},
+			{Record: Merge(target, tindByID["2002"][0]), Pass: passExact, source: tindByID["2002"][0]},
+		}
+	}
+	ds := NewDecisions()
+	for _, d := range []*Decision{
+		{OCLC: "101", Tind: "2001", Verdict: verdictAccept},
+		{OCLC: "101", Tind: "2002", Verdict: verdictReject},
+		{OCLC: "101", Tind: "2003", Verdict: verdictForce},
+	} {
+		if err := ds.Set(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	kept := ds.Apply(target, scanned(), tindByID, false)
+	got := map[string]string{}
+	for _, c := range kept {
+		got[c.Record.Tind] = c.Verdict
+		if c.Record.MatchedCount != 2 {
+			t.Errorf("candidate %s stamped %d, want 2", c.Record.Tind, c.Record.MatchedCount)
+		}
+	}
+	if len(got) != 2 || got["2001"] != verdictAccept || got["2003"] != verdictForce {
+		t.Errorf("Apply kept %v, want 2001 accepted and 2003 forced", got)
+	}
+	if kept = ds.Apply(target, scanned(), tindByID, true); len(kept) != 1 {
+		t.Errorf("Levenshtein pass kept %d, forced links belong to the first pass", len(kept))
+	}
+
+	if err := ds.Set(&Decision{OCLC: "101", Tind: "9999", Verdict: verdictForce}); err != nil {
+		t.Fatal(err)
+	}
+	if kept = ds.Apply(target, scanned(), tindByID, false); len(kept) != 2 {
+		t.Errorf("Apply kept %d with a forced TIND id missing from the export, want it skipped", len(kept))
+	}
+	if err := ds.Set(&Decision{OCLC: "101", Tind: "2001", Verdict: "maybe"}); err == nil {
+		t.Errorf("Set took an unknown verdict")
+	}
+}
+
+func TestLoadDecisionsShortRow(t *testing.T) {
+	fName := filepath.Join(t.TempDir(), "decisions.csv")
+	src := "oclc,tind,verdict,updated\n101,2001,accept,\n102,2002\n"
+	if err := os.WriteFile(fName, []byte(src), 0664); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadDecisions(fName); err == nil {
+		t.Errorf("LoadDecisions took a row without a verdict")
+	}
+}
+
+func TestDecisionsMerge(t *testing.T) {
+	ds := NewDecisions()
+	other := NewDecisions()
+	for _, tc := range []struct {
+		held, other *Decision
+	}{
+		{&Decision{OCLC: "101", Tind: "2001", Verdict: verdictAccept, Updated: "2024-05-02T10:00:00Z"},
+			&Decision{OCLC: "101", Tind: "2001", Verdict: verdictReject, Updated: "2024-05-01T10:00:00Z"}},
+		{&Decision{OCLC: "102", Tind: "2002", Verdict: verdictAccept, Updated: "2024-05-01T10:00:00Z"},
+			&Decision{OCLC: "102", Tind: "2002", Verdict: verdictReject, Updated: "2024-05-02T10:00:00Z"}},
+		{&Decision{OCLC: "103", Tind: "2003", Verdict: verdictAccept},
+			&Decision{OCLC: "103", Tind: "2003", Verdict: verdictForce, Updated: "2024-05-01T10:00:00Z"}},
+		{&Decision{OCLC: "104", Tind: "2004", Verdict: verdictAccept, Updated: "2024-05-01T10:00:00Z"},
+			&Decision{OCLC: "104", Tind: "2004", Verdict: verdictReject, Updated: "2024-05-01T10:00:00Z"}},
+	} {
+		if err := ds.Set(tc.held); err != nil {
+			t.Fatal(err)
+		}
+		if err := other.Set(tc.other); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ds.Merge(other); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []struct {
+		oclcID, tindID, verdict string
+	}{
+		{"101", "2001", verdictAccept},
+		{"102", "2002", verdictReject},
+		{"103", "2003", verdictForce},
+		{"104", "2004", verdictReject},
+	} {
+		if got := ds.Verdict(want.oclcID, want.tindID); got != want.verdict {
+			t.Errorf("%s/%s merged as %q, want %q", want.oclcID, want.tindID, got, want.verdict)
+		}
+	}
+	if got := ds.Forced("103"); len(got) != 1 {
+		t.Errorf("103 forced onto %v after the merge, want 2003", got)
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ClusterPair is a pair of records the matcher links within a cluster
+type ClusterPair struct {
+	A     string `json:"a"`
+	B     string `json:"b"`
+	Pass  string `json:"pass"`
+	Score int    `json:"score"`
+}
+
+// Cluster is a set of records of one export the matcher takes for
+// copies of one another, directly or through other members. Pairs are
+// the links which joined the members, one fewer than the members.
+type Cluster struct {
+	ID             string         `json:"cluster"`
+	Representative *Record        `json:"representative"`
+	Members        []*Record      `json:"members"`
+	Pairs          []*ClusterPair `json:"pairs"`
+}
+
+// recordID returns the id of the record at i in the export of side,
+// "oclc" or "tind", or "row N" for a record without one
+func recordID(side string, records []*Record, i int) string {
+	id := records[i].OCLC
+	if side == "tind" {
+		id = records[i].Tind
+	}
+	if id == "" {
+		return fmt.Sprintf("row %d", i+1)
+	}
+	return id
+}
+
+// blockEnds is the number of letters and digits at each end of a
+// title blockingKeys keys on
+const blockEnds = 6
+
+// blockingKeys returns the keys the Levenshtein pass of Dedupe groups
+// records by, only records sharing a key are compared: the ISBN, the
+// ISSN and the first and last letters and digits of the lower cased
+// title. Titles one edit apart keep one end in common unless they are
+// short and the edit is near the middle. Such pairs, and titles further
+// apart which a Similarity config accepts, are only compared when they
+// share an ISBN or ISSN.
+func blockingKeys(rec *Record) []string {
+	keys := []string{}
+	if isbn := strings.TrimSpace(rec.ISBN); isbn != "" {
+		keys = append(keys, "isbn:"+isbn)
+	}
+	if issn := strings.TrimSpace(rec.ISSN); issn != "" {
+		keys = append(keys, "issn:"+issn)
+	}
+	title := []rune{}
+	for _, r := range strings.ToLower(rec.Title) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			title = append(title, r)
+		}
+	}
+	if len(title) > blockEnds {
+		keys = append(keys, "first:"+string(title[:blockEnds]), "last:"+string(title[len(title)-blockEnds:]))
+	} else {
+		keys = append(keys, "title:"+string(title))
+	}
+	return keys
+}
+
+// blockIndex returns the positions of the records under each of their
+// blockingKeys
+func blockIndex(records []*Record) map[string][]int {
+	blocks := map[string][]int{}
+	for i, rec := range records {
+		for _, key := range blockingKeys(rec) {
+			blocks[key] = append(blocks[key], i)
+		}
+	}
+	return blocks
+}
+
+// representative picks the member with the most fields filled in, the
+// first in the export among equals
+func representative(members []*Record, columnNames []string) *Record {
+	var best *Record
+	bestCnt := -1
+	for _, rec := range members {
+		cnt := 0
+		for _, cName := range columnNames {
+			if strings.TrimSpace(rec.Field(cName)) != "" {
+				cnt++
+			}
+		}
+		if cnt > bestCnt {
+			best, bestCnt = rec, cnt
+		}
+	}
+	return best
+}
+
+// Dedupe clusters the records of one export, "oclc" or "tind", which
+// mc matches with each other. Links are closed transitively: when A
+// matches B and B matches C all three are one cluster. The exact pass
+// only compares records with the same title, withLevenshtein compares
+// the pairs sharing a block not already clustered together. blocks are
+// the positions of the records under each blocking key, as a store
+// keeps them, when nil they are worked out with blockIndex. Clusters of
+// one record are only returned with singletons.
+func Dedupe(ctx context.Context, mc *MatchConfig, side string, records []*Record, blocks map[string][]int, withLevenshtein, singletons bool) ([]*Cluster, error) {
+	uf := newUnionFind(len(records))
+	pairs := map[int][]*ClusterPair{}
+	link := func(i, j int, pass string) {
+		if uf.union(i, j) == true {
+			pairs[i] = append(pairs[i], &ClusterPair{
+				A:     recordID(side, records, i),
+				B:     recordID(side, records, j),
+				Pass:  pass,
+				Score: mc.Score(records[i], records[j]),
+			})
+		}
+	}
+
+	byTitle := map[string][]int{}
+	for i, rec := range records {
+		title := strings.TrimSpace(rec.Title)
+		byTitle[title] = append(byTitle[title], i)
+	}
+	for i, rec := range records {
+		if (i % 256) == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		for _, j := range byTitle[strings.TrimSpace(rec.Title)] {
+			if j <= i {
+				continue
+			}
+			if pass := mc.MatchPass(rec, records[j], false); pass != "" {
+				link(i, j, pass)
+			}
+		}
+	}
+	if withLevenshtein == true {
+		if blocks == nil {
+			blocks = blockIndex(records)
+		}
+		// keys[i] are the blocks holding record i
+		names := []string{}
+		for key := range blocks {
+			names = append(names, key)
+		}
+		sort.Strings(names)
+		keys := make([][]string, len(records))
+		for _, key := range names {
+			for _, i := range blocks[key] {
+				if i >= len(records) {
+					return nil, fmt.Errorf("block %q holds record %d of %d", key, i+1, len(records))
+				}
+				keys[i] = append(keys[i], key)
+			}
+		}
+		// compared marks the records already compared with i
+		compared := make([]int, len(records))
+		for i, rec := range records {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			for _, key := range keys[i] {
+				for _, j := range blocks[key] {
+					if j <= i || compared[j] == i+1 {
+						continue
+					}
+					compared[j] = i + 1
+					if uf.find(i) == uf.find(j) {
+						continue
+					}
+					if pass := mc.MatchPass(rec, records[j], true); pass != "" {
+						link(i, j, pass)
+					}
+				}
+			}
+		}
+	}
+
+	columnNames := oclcColumns
+	if side == "tind" {
+		columnNames = tindColumns
+	}
+	clusters := []*Cluster{}
+	for _, group := range uf.groups() {
+		if len(group) < 2 && singletons == false {
+			continue
+		}
+		c := &Cluster{ID: fmt.Sprintf("C%d", len(clusters)+1), Members: []*Record{}, Pairs: []*ClusterPair{}}
+		for _, i := range group {
+			c.Members = append(c.Members, records[i])
+			c.Pairs = append(c.Pairs, pairs[i]...)
+		}
+		c.Representative = representative(c.Members, columnNames)
+		clusters = append(clusters, c)
+	}
+	return clusters, nil
+}
+
+// writeClustersCSV writes a row per member, the cluster id, whether the
+// member represents its cluster and the cluster's size before the
+// export's own columns
+func writeClustersCSV(out io.Writer, clusters []*Cluster, columnNames []string) error {
+	w := csv.NewWriter(out)
+	w.Write(append([]string{"cluster", "representative", "size"}, columnNames...))
+	for _, c := range clusters {
+		for _, rec := range c.Members {
+			isRep := "no"
+			if rec == c.Representative {
+				isRep = "yes"
+			}
+			row := []string{c.ID, isRep, strconv.Itoa(len(c.Members))}
+			for _, cName := range columnNames {
+				row = append(row, rec.Field(cName))
+			}
+			w.Write(row)
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// dedupe clusters the duplicates within one export, "reconcile dedupe"
+func dedupe(args []string) {
+	var (
+		side        string
+		minScore    int
+		levenshtein bool
+		singletons  bool
+		format      string
+		outFName    string
+		repFName    string
+		configFName string
+	)
+	fs := flag.NewFlagSet("dedupe", flag.ExitOnError)
+	exports := addExportFlags(fs)
+	fs.StringVar(&side, "side", "oclc", "export to dedupe, oclc (-oclc) or tind (-tind)")
+	fs.IntVar(&minScore, "min-score", 0, "fields which must agree (Score) for records to be duplicates (default the matcher's threshold plus one)")
+	fs.BoolVar(&levenshtein, "levenshtein", false, "also link titles the Levenshtein pass accepts, comparing records sharing an ISBN, ISSN or either end of their title")
+	fs.BoolVar(&singletons, "all", false, "output records without duplicates too, as clusters of one")
+	fs.StringVar(&format, "format", "csv", "output format, csv (a row per record) or jsonl (a line per cluster)")
+	fs.StringVar(&outFName, "o", "", "write the clusters to this file instead of stdout")
+	fs.StringVar(&repFName, "representatives", "", "write the export without its duplicates, one representative per cluster, to this CSV file")
+	fs.StringVar(&configFName, "config", "", "matcher config written by reconcile tune (default the built in matcher)")
+	logOpts := addLogFlags(fs)
+	fs.Parse(args)
+	if err := logOpts.setup(); err != nil {
+		log.Fatal(err)
+	}
+	if side != "oclc" && side != "tind" {
+		log.Fatalf("unknown side %q, use oclc or tind", side)
+	}
+	if format != "csv" && format != "jsonl" {
+		log.Fatalf("unknown format %q, use csv or jsonl", format)
+	}
+	if configFName != "" {
+		mc, err := LoadMatchConfig(configFName)
+		if err != nil {
+			log.Fatalf("Can't read %s, %s", configFName, err)
+		}
+		matcher = mc
+	}
+	mc := matcher.copy()
+	if minScore > 0 {
+		mc.Threshold = minScore - 1
+	}
+
+	startT := time.Now()
+	records, err := exports.loadSide(side)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var blocks map[string][]int
+	if levenshtein == true {
+		if blocks, err = exports.loadBlocks(side); err != nil {
+			log.Fatal(err)
+		}
+	}
+	ctx, stop := interruptible()
+	defer stop()
+	clusters, err := Dedupe(ctx, mc, side, records, blocks, levenshtein, singletons || repFName != "")
+	if err != nil {
+		log.Fatal(err)
+	}
+	duplicates, clustered := 0, 0
+	for _, c := range clusters {
+		if len(c.Members) > 1 {
+			duplicates += len(c.Members) - 1
+			clustered++
+		}
+	}
+	slog.Info("deduped", "side", side, "records", len(records), "clusters", clustered, "duplicates", duplicates, runningTime(startT))
+
+	columnNames := oclcColumns
+	if side == "tind" {
+		columnNames = tindColumns
+	}
+	if repFName != "" {
+		reps := []*Record{}
+		for _, c := range clusters {
+			reps = append(reps, c.Representative)
+		}
+		if err := WriteExport(repFName, columnNames, reps); err != nil {
+			log.Fatalf("Can't write %s, %s", repFName, err)
+		}
+		// Only -all asks for the clusters of one in the output
+		if singletons == false {
+			multiple := []*Cluster{}
+			for _, c := range clusters {
+				if len(c.Members) > 1 {
+					c.ID = fmt.Sprintf("C%d", len(multiple)+1)
+					multiple = append(multiple, c)
+				}
+			}
+			clusters = multiple
+		}
+	}
+
+	out := os.Stdout
+	if outFName != "" {
+		if out, err = os.Create(outFName); err != nil {
+			log.Fatalf("Can't create %s, %s", outFName, err)
+		}
+		defer out.Close()
+	}
+	if format == "jsonl" {
+		for _, c := range clusters {
+			src, err := json.Marshal(c)
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Fprintf(out, "%s\n", src)
+		}
+	} else if err := writeClustersCSV(out, clusters, columnNames); err != nil {
+		log.Fatal(err)
+	}
+}
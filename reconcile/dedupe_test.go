@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestBlockingKeys(t *testing.T) {
+	rec := testRecord()
+	want := []string{"isbn:9780201500646", "issn:0012-3456", "first:thenat", "last:flight"}
+	if got := blockingKeys(rec); reflect.DeepEqual(got, want) == false {
+		t.Errorf("blockingKeys = %v, want %v", got, want)
+	}
+	rec.ISBN, rec.ISSN, rec.Title = "", "", "Light!"
+	if got := blockingKeys(rec); reflect.DeepEqual(got, []string{"title:light"}) == false {
+		t.Errorf("short title keys %v", got)
+	}
+	// One edit at either end leaves the other end to block on
+	for _, title := range []string{"Thee nature of light", "The nature of lights", "the nature of light"} {
+		typo := testRecord()
+		typo.ISBN, typo.ISSN, typo.Title = "", "", title
+		shared := false
+		for _, a := range blockingKeys(typo) {
+			for _, b := range blockingKeys(testRecord()) {
+				shared = shared || a == b
+			}
+		}
+		if shared == false {
+			t.Errorf("%q shares no blocking key with the original title", title)
+		}
+	}
+}
+
+func TestDedupe(t *testing.T) {
+	records := []*Record{}
+	for i, title := range []string{"The nature of light", "The nature of ligt", "The nature of light", "Principles of heat", "Principles of heat"} {
+		rec := testRecord()
+		rec.ISBN, rec.ISSN, rec.Title = "", "", title
+		if i != 1 {
+			rec.Tind = string(rune('A' + i))
+		}
+		records = append(records, rec)
+	}
+	for _, tc := range []struct {
+		withLevenshtein bool
+		sizes           []int
+	}{
+		{false, []int{2, 2}},
+		{true, []int{3, 2}},
+	} {
+		clusters, err := Dedupe(context.Background(), matcher, "tind", records, nil, tc.withLevenshtein, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes := []int{}
+		for _, c := range clusters {
+			sizes = append(sizes, len(c.Members))
+			if len(c.Pairs) != len(c.Members)-1 {
+				t.Errorf("cluster %s has %d members joined by %d pairs", c.ID, len(c.Members), len(c.Pairs))
+			}
+			for _, p := range c.Pairs {
+				if p.A == "" || p.B == "" {
+					t.Errorf("cluster %s pair without an id %+v", c.ID, p)
+				}
+				if p.Pass == passLevenshtein && p.B != "row 2" {
+					t.Errorf("Levenshtein pair %s/%s, want the record without an id as row 2", p.A, p.B)
+				}
+			}
+		}
+		if reflect.DeepEqual(sizes, tc.sizes) == false {
+			t.Errorf("Levenshtein %t: cluster sizes %v, want %v", tc.withLevenshtein, sizes, tc.sizes)
+		}
+	}
+}
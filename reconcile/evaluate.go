@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Label is a known answer for an OCLC/TIND pair
+type Label struct {
+	OCLC  string `json:"oclc"`
+	Tind  string `json:"tind"`
+	Match bool   `json:"match"`
+}
+
+// parseLabel reads the label column of a labels file
+func parseLabel(val string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(val)) {
+	case "match", "yes", "y", "true", "1":
+		return true, nil
+	case "nonmatch", "non-match", "no", "n", "false", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("unknown label %q, use match or nonmatch", val)
+}
+
+// LoadLabels reads a labels CSV file (oclc,tind,label) where label is
+// match or nonmatch. The first row is a header.
+func LoadLabels(fName string) ([]*Label, error) {
+	fp, err := os.Open(fName)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	r := csv.NewReader(fp)
+	r.FieldsPerRecord = -1
+	labels := []*Label{}
+	for i := 0; ; i++ {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		//NOTE: We need to skip the header row
+		if i == 0 {
+			continue
+		}
+		if len(row) < 3 {
+			return nil, fmt.Errorf("%s row %d, expected oclc,tind,label", fName, i+1)
+		}
+		match, err := parseLabel(row[2])
+		if err != nil {
+			return nil, fmt.Errorf("%s row %d, %s", fName, i+1, err)
+		}
+		labels = append(labels, &Label{OCLC: row[0], Tind: row[1], Match: match})
+	}
+	return labels, nil
+}
+
+// EvalPair is a pair the matcher got wrong
+type EvalPair struct {
+	OCLC      string `json:"oclc"`
+	Tind      string `json:"tind"`
+	Pass      string `json:"pass,omitempty"`
+	Score     int    `json:"score"`
+	OCLCTitle string `json:"oclc_title"`
+	TindTitle string `json:"tind_title"`
+	// Labeled is false for a predicted pair missing from the labels
+	Labeled bool `json:"labeled"`
+}
+
+// Evaluation compares the pairs a matcher predicts with the labels
+type Evaluation struct {
+	TruePositives  int         `json:"true_positives"`
+	FalsePositives int         `json:"false_positives"`
+	FalseNegatives int         `json:"false_negatives"`
+	TrueNegatives  int         `json:"true_negatives"`
+	Unlabeled      int         `json:"unlabeled"`
+	Missing        int         `json:"missing"`
+	Precision      float64     `json:"precision"`
+	Recall         float64     `json:"recall"`
+	F1             float64     `json:"f1"`
+	FalsePositive  []*EvalPair `json:"false_positive_pairs"`
+	FalseNegative  []*EvalPair `json:"false_negative_pairs"`
+}
+
+// prediction is a candidate found for an OCLC record
+type prediction struct {
+	oclcID string
+	c      *Candidate
+}
+
+// predict returns the candidates mc finds for each target as a run
+// finds them, the Levenshtein pass only for targets the exact pass left
+// unmatched. Predictions are keyed by pairKey.
+func predict(ctx context.Context, mc *MatchConfig, targets, sources []*Record) (map[string]*prediction, error) {
+	predicted := map[string]*prediction{}
+	for _, target := range targets {
+		matched, err := mc.Scan(ctx, target, sources, false)
+		if err != nil {
+			return nil, err
+		}
+		if len(matched) == 0 {
+			if matched, err = mc.Scan(ctx, target, sources, true); err != nil {
+				return nil, err
+			}
+		}
+		for _, c := range matched {
+			predicted[pairKey(target.OCLC, c.source.Tind)] = &prediction{oclcID: target.OCLC, c: c}
+		}
+	}
+	return predicted, nil
+}
+
+// evalSet is a set of labels resolved against the exports
+type evalSet struct {
+	oclcByID map[string]*Record
+	tindByID map[string]*Record
+	// targets are the OCLC records the labels name, in label order
+	targets  []*Record
+	labeled  map[string]*Label
+	missing  int
+	complete bool
+}
+
+// newEvalSet resolves labels against the OCLC and TIND records, labels
+// naming records missing from them are counted and skipped
+func newEvalSet(labels []*Label, oclc, tind []*Record, complete bool) *evalSet {
+	es := &evalSet{
+		oclcByID: map[string]*Record{},
+		tindByID: map[string]*Record{},
+		targets:  []*Record{},
+		labeled:  map[string]*Label{},
+		complete: complete,
+	}
+	for _, rec := range oclc {
+		es.oclcByID[rec.OCLC] = rec
+	}
+	for _, rec := range tind {
+		es.tindByID[rec.Tind] = rec
+	}
+	seen := map[string]bool{}
+	for _, l := range labels {
+		target, source := es.oclcByID[l.OCLC], es.tindByID[l.Tind]
+		if target == nil || source == nil {
+			es.missing++
+			continue
+		}
+		es.labeled[pairKey(l.OCLC, l.Tind)] = l
+		if seen[l.OCLC] == false {
+			es.targets = append(es.targets, target)
+			seen[l.OCLC] = true
+		}
+	}
+	if es.missing > 0 {
+		slog.Warn("labels naming records missing from the exports were skipped", "missing", es.missing)
+	}
+	return es
+}
+
+// Evaluate runs the matcher configured by mc over the OCLC records
+// named in labels and scores its predictions. With complete, the labels
+// are taken to list every true match of the OCLC records they name so
+// predicted pairs without a label are false positives, otherwise they
+// are only counted as unlabeled.
+func Evaluate(ctx context.Context, mc *MatchConfig, labels []*Label, oclc, tind []*Record, complete bool) (*Evaluation, error) {
+	es := newEvalSet(labels, oclc, tind, complete)
+	predicted, err := predict(ctx, mc, es.targets, tind)
+	if err != nil {
+		return nil, err
+	}
+	return es.score(mc, predicted), nil
+}
+
+// score compares the predictions with the labels
+func (es *evalSet) score(mc *MatchConfig, predicted map[string]*prediction) *Evaluation {
+	ev := &Evaluation{Missing: es.missing, FalsePositive: []*EvalPair{}, FalseNegative: []*EvalPair{}}
+	pair := func(oclcID, tindID string, c *Candidate, isLabeled bool) *EvalPair {
+		target, source := es.oclcByID[oclcID], es.tindByID[tindID]
+		p := &EvalPair{OCLC: oclcID, Tind: tindID, OCLCTitle: target.Title, TindTitle: source.Title, Labeled: isLabeled}
+		if c != nil {
+			p.Pass, p.Score = c.Pass, c.Score
+		} else {
+			p.Score = mc.Score(target, source)
+		}
+		return p
+	}
+	for key, l := range es.labeled {
+		p, ok := predicted[key]
+		switch {
+		case l.Match && ok:
+			ev.TruePositives++
+		case l.Match:
+			ev.FalseNegatives++
+			ev.FalseNegative = append(ev.FalseNegative, pair(l.OCLC, l.Tind, nil, true))
+		case ok:
+			ev.FalsePositives++
+			ev.FalsePositive = append(ev.FalsePositive, pair(l.OCLC, l.Tind, p.c, true))
+		default:
+			ev.TrueNegatives++
+		}
+	}
+	for key, p := range predicted {
+		if _, ok := es.labeled[key]; ok == true {
+			continue
+		}
+		if es.complete == true {
+			ev.FalsePositives++
+			ev.FalsePositive = append(ev.FalsePositive, pair(p.oclcID, p.c.source.Tind, p.c, false))
+		} else {
+			ev.Unlabeled++
+		}
+	}
+	ev.Precision = rate(ev.TruePositives, ev.TruePositives+ev.FalsePositives)
+	ev.Recall = rate(ev.TruePositives, ev.TruePositives+ev.FalseNegatives)
+	if ev.Precision+ev.Recall > 0 {
+		ev.F1 = 2 * ev.Precision * ev.Recall / (ev.Precision + ev.Recall)
+	}
+	for _, pairs := range [][]*EvalPair{ev.FalsePositive, ev.FalseNegative} {
+		sort.Slice(pairs, func(i, j int) bool {
+			if pairs[i].OCLC != pairs[j].OCLC {
+				return pairs[i].OCLC < pairs[j].OCLC
+			}
+			return pairs[i].Tind < pairs[j].Tind
+		})
+	}
+	return ev
+}
+
+// WriteText writes the evaluation as aligned plain text
+func (ev *Evaluation) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Precision\t%.4f\n", ev.Precision)
+	fmt.Fprintf(tw, "Recall\t%.4f\n", ev.Recall)
+	fmt.Fprintf(tw, "F1\t%.4f\n\n", ev.F1)
+	fmt.Fprintf(tw, "\tpredicted match\tpredicted nonmatch\n")
+	fmt.Fprintf(tw, "actual match\t%d\t%d\n", ev.TruePositives, ev.FalseNegatives)
+	fmt.Fprintf(tw, "actual nonmatch\t%d\t%d\n", ev.FalsePositives, ev.TrueNegatives)
+	if ev.Unlabeled > 0 {
+		fmt.Fprintf(tw, "\nPredicted pairs without a label\t%d\n", ev.Unlabeled)
+	}
+	if ev.Missing > 0 {
+		fmt.Fprintf(tw, "\nLabels naming missing records\t%d\n", ev.Missing)
+	}
+	for _, section := range []struct {
+		name  string
+		pairs []*EvalPair
+	}{
+		{"False positives", ev.FalsePositive},
+		{"False negatives", ev.FalseNegative},
+	} {
+		if len(section.pairs) == 0 {
+			continue
+		}
+		fmt.Fprintf(tw, "\n%s\toclc\ttind\tpass\tscore\toclc title\ttind title\n", section.name)
+		for _, p := range section.pairs {
+			note := ""
+			if p.Labeled == false {
+				note = "unlabeled"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", note, p.OCLC, p.Tind, p.Pass, p.Score, p.OCLCTitle, p.TindTitle)
+		}
+	}
+	return tw.Flush()
+}
+
+// evaluate scores the matcher against labeled pairs, "reconcile evaluate"
+func evaluate(args []string) {
+	var (
+		labelsFName string
+		complete    bool
+		format      string
+		outFName    string
+		configFName string
+	)
+	fs := flag.NewFlagSet("evaluate", flag.ExitOnError)
+	exports := addExportFlags(fs)
+	fs.StringVar(&labelsFName, "labels", "", "labeled pairs, a CSV file of oclc,tind,label where label is match or nonmatch")
+	fs.BoolVar(&complete, "complete", false, "the labels list every true match of the OCLC records they name, unlabeled predictions are false positives")
+	fs.StringVar(&format, "format", "text", "output format, text or json")
+	fs.StringVar(&outFName, "o", "", "write the evaluation to this file instead of stdout")
+	fs.StringVar(&configFName, "config", "", "matcher config written by reconcile tune (default the built in matcher)")
+	logOpts := addLogFlags(fs)
+	fs.Parse(args)
+	if err := logOpts.setup(); err != nil {
+		log.Fatal(err)
+	}
+	if labelsFName == "" {
+		log.Fatal("-labels is required")
+	}
+	if format != "text" && format != "json" {
+		log.Fatalf("unknown format %q, use text or json", format)
+	}
+
+	if configFName != "" {
+		mc, err := LoadMatchConfig(configFName)
+		if err != nil {
+			log.Fatalf("Can't read %s, %s", configFName, err)
+		}
+		matcher = mc
+	}
+
+	startT := time.Now()
+	labels, err := LoadLabels(labelsFName)
+	if err != nil {
+		log.Fatalf("Can't read %s, %s", labelsFName, err)
+	}
+	oclc, tind, err := exports.load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx, stop := interruptible()
+	defer stop()
+	ev, err := Evaluate(ctx, matcher, labels, oclc, tind, complete)
+	if err != nil {
+		log.Fatal(err)
+	}
+	slog.Info("evaluated", "labels", len(labels), "precision", ev.Precision, "recall", ev.Recall, "f1", ev.F1, runningTime(startT))
+
+	out := os.Stdout
+	if outFName != "" {
+		if out, err = os.Create(outFName); err != nil {
+			log.Fatalf("Can't create %s, %s", outFName, err)
+		}
+		defer out.Close()
+	}
+	if format == "json" {
+		src, err := json.MarshalIndent(ev, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Fprintf(out, "%s\n", src)
+	} else if err := ev.WriteText(out); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseLabel(t *testing.T) {
+	for _, tc := range []struct {
+		val   string
+		match bool
+		ok    bool
+	}{
+		{"match", true, true},
+		{" Match ", true, true},
+		{"yes", true, true},
+		{"1", true, true},
+		{"nonmatch", false, true},
+		{"non-match", false, true},
+		{"no", false, true},
+		{"0", false, true},
+		{"maybe", false, false},
+		{"", false, false},
+	} {
+		match, err := parseLabel(tc.val)
+		if (err == nil) != tc.ok || match != tc.match {
+			t.Errorf("parseLabel(%q) = %t, %v", tc.val, match, err)
+		}
+	}
+}
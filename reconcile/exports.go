@@ -0,0 +1,112 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// exportOptions are the flags naming the OCLC and TIND exports, shared
+// by the commands which reconcile them outside a run
+type exportOptions struct {
+	oclcFName   string
+	tindFName   string
+	oclcFormat  string
+	tindFormat  string
+	oclcMapping string
+	tindMapping string
+	storeFName  string
+}
+
+// addExportFlags adds the flags naming the exports to fs
+func addExportFlags(fs *flag.FlagSet) *exportOptions {
+	o := new(exportOptions)
+	fs.StringVar(&o.oclcFName, "oclc", "data/rerun-oclc-all.csv", "OCLC export")
+	fs.StringVar(&o.tindFName, "tind", "data/rerun-tind-all.csv", "TIND export")
+	fs.StringVar(&o.oclcFormat, "oclc-format", "", "OCLC export format, csv, marc or marcxml (default guessed from extension)")
+	fs.StringVar(&o.tindFormat, "tind-format", "", "TIND export format, csv, marc or marcxml (default guessed from extension)")
+	fs.StringVar(&o.oclcMapping, "oclc-mapping", "", "JSON file overriding the MARC field mapping for the OCLC export")
+	fs.StringVar(&o.tindMapping, "tind-mapping", "", "JSON file overriding the MARC field mapping for the TIND export")
+	fs.StringVar(&o.storeFName, "store", "", "read the records from this store instead of the exports")
+	return o
+}
+
+// load reads the OCLC and TIND records, from the store when one is given
+func (o *exportOptions) load() ([]*Record, []*Record, error) {
+	startT := time.Now()
+	if o.storeFName != "" {
+		store, err := OpenStore(o.storeFName)
+		if err != nil {
+			return nil, nil, fmt.Errorf("can't open %s, %s", o.storeFName, err)
+		}
+		defer store.Close()
+		oclc, err := store.Records("oclc")
+		if err != nil {
+			return nil, nil, fmt.Errorf("can't read OCLC records from %s, %s", o.storeFName, err)
+		}
+		tind, err := store.Records("tind")
+		if err != nil {
+			return nil, nil, fmt.Errorf("can't read TIND records from %s, %s", o.storeFName, err)
+		}
+		slog.Info("read records from store", "store", o.storeFName, "oclc", len(oclc), "tind", len(tind), runningTime(startT))
+		return oclc, tind, nil
+	}
+	oclc, err := loadExport("oclc", o.oclcFName, o.oclcFormat, o.oclcMapping)
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't read %s, %s", o.oclcFName, err)
+	}
+	tind, err := loadExport("tind", o.tindFName, o.tindFormat, o.tindMapping)
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't read %s, %s", o.tindFName, err)
+	}
+	slog.Info("read records", "oclc", len(oclc), "tind", len(tind), runningTime(startT))
+	return oclc, tind, nil
+}
+
+// loadSide reads the records of one export, "oclc" or "tind", from the
+// store when one is given
+func (o *exportOptions) loadSide(side string) ([]*Record, error) {
+	startT := time.Now()
+	if o.storeFName != "" {
+		store, err := OpenStore(o.storeFName)
+		if err != nil {
+			return nil, fmt.Errorf("can't open %s, %s", o.storeFName, err)
+		}
+		defer store.Close()
+		records, err := store.Records(side)
+		if err != nil {
+			return nil, fmt.Errorf("can't read %s records from %s, %s", side, o.storeFName, err)
+		}
+		slog.Info("read records from store", "store", o.storeFName, side, len(records), runningTime(startT))
+		return records, nil
+	}
+	fName, format, mapping := o.oclcFName, o.oclcFormat, o.oclcMapping
+	if side == "tind" {
+		fName, format, mapping = o.tindFName, o.tindFormat, o.tindMapping
+	}
+	records, err := loadExport(side, fName, format, mapping)
+	if err != nil {
+		return nil, fmt.Errorf("can't read %s, %s", fName, err)
+	}
+	slog.Info("read records", side, len(records), runningTime(startT))
+	return records, nil
+}
+
+// loadBlocks reads the positions of the records of side under each
+// blocking key from the store, nil without a store
+func (o *exportOptions) loadBlocks(side string) (map[string][]int, error) {
+	if o.storeFName == "" {
+		return nil, nil
+	}
+	store, err := OpenStore(o.storeFName)
+	if err != nil {
+		return nil, fmt.Errorf("can't open %s, %s", o.storeFName, err)
+	}
+	defer store.Close()
+	blocks, err := store.Blocks(side)
+	if err != nil {
+		return nil, fmt.Errorf("can't read %s blocking keys from %s, %s", side, o.storeFName, err)
+	}
+	return blocks, nil
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GenerateOptions control the synthetic exports Generate makes. The
+// rates are the chance of each perturbation for a matched TIND record.
+type GenerateOptions struct {
+	// Records is the number of OCLC records
+	Records int
+	// Matched is the share of OCLC records with a TIND copy
+	Matched float64
+	// TindOnly is the number of TIND records for each OCLC record
+	// without an OCLC copy
+	TindOnly float64
+	// Editions is the share of matched records whose TIND export also
+	// holds another edition, labeled nonmatch
+	Editions float64
+
+	Typos             float64
+	ISBNFormats       float64
+	MissingSubtitles  float64
+	PublisherVariants float64
+
+	Seed int64
+}
+
+// Synthetic is a generated pair of exports and their ground truth
+type Synthetic struct {
+	OCLC   []*Record
+	Tind   []*Record
+	Labels []*Label
+	// Perturbations lists the changes made to each labeled pair, keyed by pairKey
+	Perturbations map[string][]string
+}
+
+var (
+	synthTitleWords = []string{
+		"light", "matter", "energy", "waves", "fields", "stars", "galaxies",
+		"rivers", "mountains", "cities", "machines", "computation", "numbers",
+		"geometry", "motion", "heat", "time", "space", "atoms", "cells",
+		"genes", "evolution", "climate", "oceans", "earthquakes", "rocks",
+		"engines", "bridges", "circuits", "signals", "language", "history",
+		"memory", "music", "networks", "chemistry", "symmetry", "chaos",
+	}
+	synthTitlePatterns = []string{
+		"Introduction to %s",
+		"Principles of %s",
+		"The %s of %s",
+		"%s and %s",
+		"A history of %s",
+		"Essays on %s and %s",
+		"Advanced %s",
+		"%s in the modern world",
+		"Lectures on %s",
+		"The theory of %s",
+	}
+	synthSubTitles = []string{
+		"an introduction", "a survey", "theory and practice", "selected papers",
+		"a textbook for students", "proceedings of a symposium", "new perspectives",
+		"a reader", "methods and applications", "the first hundred years",
+	}
+	synthSurnames = []string{
+		"Feynman", "Pauling", "Millikan", "Hale", "Zwicky", "Richter", "Gutenberg",
+		"Noyes", "Bacher", "Delbrück", "Morgan", "Sturtevant", "Kármán", "Mead",
+		"Anderson", "Fowler", "Gell-Mann", "Thorne", "Arnold", "Hood", "Ramo",
+	}
+	synthGivenNames = []string{
+		"Richard", "Linus", "Robert", "George", "Fritz", "Charles", "Beno",
+		"Arthur", "Max", "Thomas", "Alfred", "Theodore", "Carver", "Carl",
+		"William", "Murray", "Kip", "Frances", "Leroy", "Simon", "Ann",
+	}
+	// synthPublishers are a publisher's usual name followed by variants
+	// catalogers use for it
+	synthPublishers = [][]string{
+		{"University of California Press", "Univ. of California Press", "University of California Press,"},
+		{"John Wiley & Sons", "Wiley", "J. Wiley"},
+		{"Springer", "Springer-Verlag", "Springer Verlag"},
+		{"Oxford University Press", "Oxford Univ. Press", "OUP"},
+		{"MIT Press", "The MIT Press", "M.I.T. Press"},
+		{"Cambridge University Press", "Cambridge Univ. Press", "CUP"},
+		{"Princeton University Press", "Princeton Univ. Press"},
+		{"Elsevier", "Elsevier Science", "Elsevier Science Publishers"},
+		{"W. W. Norton", "Norton", "W.W. Norton & Company"},
+		{"Academic Press", "Academic Press, Inc."},
+		{"California Institute of Technology", "Caltech", "Calif. Inst. of Technology"},
+	}
+)
+
+// synthGenerator holds the state of one Generate call
+type synthGenerator struct {
+	rng    *rand.Rand
+	opts   *GenerateOptions
+	nextID int
+}
+
+func (g *synthGenerator) pick(vals []string) string {
+	return vals[g.rng.Intn(len(vals))]
+}
+
+func (g *synthGenerator) chance(rate float64) bool {
+	return g.rng.Float64() < rate
+}
+
+func (g *synthGenerator) title() string {
+	pattern := g.pick(synthTitlePatterns)
+	args := []interface{}{}
+	for i := 0; i < strings.Count(pattern, "%s"); i++ {
+		args = append(args, g.pick(synthTitleWords))
+	}
+	return fmt.Sprintf(pattern, args...)
+}
+
+// isbn13 returns a random ISBN-13 with a correct check digit
+func (g *synthGenerator) isbn13() string {
+	digits := "978" + fmt.Sprintf("%09d", g.rng.Intn(1000000000))
+	sum := 0
+	for i, c := range digits {
+		d := int(c - '0')
+		if i%2 == 1 {
+			d *= 3
+		}
+		sum += d
+	}
+	return digits + strconv.Itoa((10-sum%10)%10)
+}
+
+// record returns a new OCLC shaped record, without ids
+func (g *synthGenerator) record() *Record {
+	year := 1900 + g.rng.Intn(125)
+	rec := &Record{
+		MaterialType: "a",
+		MonoOrSerial: "m",
+		Date1:        strconv.Itoa(year),
+		Title:        g.title(),
+		Author:       g.pick(synthSurnames) + ", " + g.pick(synthGivenNames),
+		Publisher:    synthPublishers[g.rng.Intn(len(synthPublishers))][0],
+		Year:         strconv.Itoa(year),
+		Pagination:   fmt.Sprintf("%d p.", 80+g.rng.Intn(800)),
+	}
+	if g.chance(0.6) {
+		rec.SubTitle = g.pick(synthSubTitles)
+	}
+	if g.chance(0.1) {
+		rec.Form = "o"
+	}
+	if g.chance(0.05) {
+		rec.MonoOrSerial = "s"
+		rec.ISSN = fmt.Sprintf("%04d-%04d", g.rng.Intn(10000), g.rng.Intn(10000))
+		rec.Author = ""
+	} else if year >= 1970 {
+		rec.ISBN = g.isbn13()
+	}
+	return rec
+}
+
+// id returns the next record id, unique across both exports
+func (g *synthGenerator) id() string {
+	g.nextID++
+	return strconv.Itoa(g.nextID)
+}
+
+// typo makes one typing mistake in val: a letter dropped, doubled,
+// replaced or two letters swapped
+func (g *synthGenerator) typo(val string) string {
+	r := []rune(val)
+	if len(r) < 2 {
+		return val
+	}
+	i := g.rng.Intn(len(r) - 1)
+	switch g.rng.Intn(4) {
+	case 0:
+		r = append(r[:i], r[i+1:]...)
+	case 1:
+		r = append(r[:i+1], r[i:]...)
+	case 2:
+		r[i] = rune('a' + g.rng.Intn(26))
+	default:
+		r[i], r[i+1] = r[i+1], r[i]
+	}
+	return string(r)
+}
+
+// isbnFormat writes an ISBN-13 another way: hyphenated, as an ISBN-10
+// or with a binding qualifier
+func (g *synthGenerator) isbnFormat(isbn string) string {
+	if len(isbn) != 13 {
+		return isbn
+	}
+	switch g.rng.Intn(3) {
+	case 0:
+		return isbn[0:3] + "-" + isbn[3:4] + "-" + isbn[4:7] + "-" + isbn[7:12] + "-" + isbn[12:]
+	case 1:
+		digits := isbn[3:12]
+		sum := 0
+		for i, c := range digits {
+			sum += (10 - i) * int(c-'0')
+		}
+		check := (11 - sum%11) % 11
+		if check == 10 {
+			return digits + "X"
+		}
+		return digits + strconv.Itoa(check)
+	}
+	return isbn + " (pbk.)"
+}
+
+// publisherVariant returns another name for a publisher Generate uses
+func (g *synthGenerator) publisherVariant(publisher string) string {
+	for _, names := range synthPublishers {
+		if names[0] == publisher {
+			return g.pick(names[1:])
+		}
+	}
+	return publisher
+}
+
+// perturb returns a TIND copy of rec with the perturbations drawn for
+// it and their names
+func (g *synthGenerator) perturb(rec *Record) (*Record, []string) {
+	tind := new(Record)
+	*tind = *rec
+	tind.OCLC = ""
+	perturbations := []string{}
+	if g.chance(g.opts.Typos) {
+		tind.Title = g.typo(tind.Title)
+		perturbations = append(perturbations, "typo")
+	}
+	if tind.ISBN != "" && g.chance(g.opts.ISBNFormats) {
+		tind.ISBN = g.isbnFormat(tind.ISBN)
+		perturbations = append(perturbations, "isbn_format")
+	}
+	if tind.SubTitle != "" && g.chance(g.opts.MissingSubtitles) {
+		tind.SubTitle = ""
+		perturbations = append(perturbations, "missing_subtitle")
+	}
+	if g.chance(g.opts.PublisherVariants) {
+		if variant := g.publisherVariant(tind.Publisher); variant != tind.Publisher {
+			tind.Publisher = variant
+			perturbations = append(perturbations, "publisher_variant")
+		}
+	}
+	return tind, perturbations
+}
+
+// edition returns another edition of rec, same title and author but a
+// later year, new ISBN and pagination
+func (g *synthGenerator) edition(rec *Record) *Record {
+	ed := new(Record)
+	*ed = *rec
+	ed.OCLC = ""
+	year, _ := strconv.Atoi(rec.Year)
+	year += 1 + g.rng.Intn(10)
+	ed.Date1, ed.Year = strconv.Itoa(year), strconv.Itoa(year)
+	ed.Pagination = fmt.Sprintf("%d p.", 80+g.rng.Intn(800))
+	if ed.ISBN != "" || year >= 1970 {
+		ed.ISBN = g.isbn13()
+	}
+	return ed
+}
+
+// Generate makes a pair of exports with known answers. Matched OCLC
+// records have a TIND copy, perturbed as opts says, and some another
+// edition too. The TIND export is shuffled and padded with records
+// that have no OCLC copy.
+func Generate(opts *GenerateOptions) *Synthetic {
+	g := &synthGenerator{rng: rand.New(rand.NewSource(opts.Seed)), opts: opts, nextID: 1000}
+	syn := &Synthetic{
+		OCLC:          []*Record{},
+		Tind:          []*Record{},
+		Labels:        []*Label{},
+		Perturbations: map[string][]string{},
+	}
+	for i := 0; i < opts.Records; i++ {
+		rec := g.record()
+		rec.OCLC = g.id()
+		syn.OCLC = append(syn.OCLC, rec)
+		if g.chance(opts.Matched) == false {
+			continue
+		}
+		tind, perturbations := g.perturb(rec)
+		tind.Tind = g.id()
+		syn.Tind = append(syn.Tind, tind)
+		syn.Labels = append(syn.Labels, &Label{OCLC: rec.OCLC, Tind: tind.Tind, Match: true})
+		syn.Perturbations[pairKey(rec.OCLC, tind.Tind)] = perturbations
+		if g.chance(opts.Editions) {
+			ed := g.edition(rec)
+			ed.Tind = g.id()
+			syn.Tind = append(syn.Tind, ed)
+			syn.Labels = append(syn.Labels, &Label{OCLC: rec.OCLC, Tind: ed.Tind, Match: false})
+			syn.Perturbations[pairKey(rec.OCLC, ed.Tind)] = []string{"edition"}
+		}
+	}
+	for i := 0; i < int(opts.TindOnly*float64(opts.Records)); i++ {
+		rec := g.record()
+		rec.Tind = g.id()
+		syn.Tind = append(syn.Tind, rec)
+	}
+	g.rng.Shuffle(len(syn.Tind), func(i, j int) {
+		syn.Tind[i], syn.Tind[j] = syn.Tind[j], syn.Tind[i]
+	})
+	return syn
+}
+
+// WriteExport writes records as a CSV export with columnNames as the
+// header, the layout RowToRecord reads
+func WriteExport(fName string, columnNames []string, records []*Record) error {
+	fp, err := os.Create(fName)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	w := csv.NewWriter(fp)
+	w.Write(columnNames)
+	row := make([]string, len(columnNames))
+	for _, rec := range records {
+		for i, cName := range columnNames {
+			row[i] = rec.Field(cName)
+		}
+		w.Write(row)
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// WriteLabels writes the ground truth in the format LoadLabels reads,
+// with the perturbations of each pair as a fourth column
+func (syn *Synthetic) WriteLabels(fName string) error {
+	fp, err := os.Create(fName)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	w := csv.NewWriter(fp)
+	w.Write([]string{"oclc", "tind", "label", "perturbations"})
+	for _, l := range syn.Labels {
+		label := "nonmatch"
+		if l.Match == true {
+			label = "match"
+		}
+		w.Write([]string{l.OCLC, l.Tind, label, strings.Join(syn.Perturbations[pairKey(l.OCLC, l.Tind)], ";")})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// generate writes synthetic exports and their labels, "reconcile generate"
+func generate(args []string) {
+	var (
+		oclcFName   string
+		tindFName   string
+		labelsFName string
+	)
+	opts := new(GenerateOptions)
+	fs := flag.NewFlagSet("generate", flag.ExitOnError)
+	fs.StringVar(&oclcFName, "oclc", "synthetic-oclc.csv", "write the OCLC export to this file")
+	fs.StringVar(&tindFName, "tind", "synthetic-tind.csv", "write the TIND export to this file")
+	fs.StringVar(&labelsFName, "labels", "synthetic-labels.csv", "write the ground truth (oclc,tind,label,perturbations) to this file")
+	fs.IntVar(&opts.Records, "n", 1000, "OCLC records to generate")
+	fs.Float64Var(&opts.Matched, "matched", 0.8, "share of OCLC records with a TIND copy")
+	fs.Float64Var(&opts.TindOnly, "tind-only", 0.2, "TIND records without an OCLC copy, per OCLC record")
+	fs.Float64Var(&opts.Editions, "editions", 0.05, "share of matched records with another edition in TIND, labeled nonmatch")
+	fs.Float64Var(&opts.Typos, "typos", 0.1, "chance of a typo in a TIND copy's title")
+	fs.Float64Var(&opts.ISBNFormats, "isbn-formats", 0.1, "chance of a TIND copy's ISBN written another way")
+	fs.Float64Var(&opts.MissingSubtitles, "missing-subtitles", 0.1, "chance of a TIND copy losing its subtitle")
+	fs.Float64Var(&opts.PublisherVariants, "publisher-variants", 0.1, "chance of a TIND copy naming its publisher another way")
+	fs.Int64Var(&opts.Seed, "seed", 1, "random seed, the same seed and options give the same exports")
+	logOpts := addLogFlags(fs)
+	fs.Parse(args)
+	if err := logOpts.setup(); err != nil {
+		log.Fatal(err)
+	}
+	if opts.Records < 1 {
+		log.Fatal("-n must be at least 1")
+	}
+
+	startT := time.Now()
+	syn := Generate(opts)
+	if err := WriteExport(oclcFName, oclcColumns, syn.OCLC); err != nil {
+		log.Fatalf("Can't write %s, %s", oclcFName, err)
+	}
+	if err := WriteExport(tindFName, tindColumns, syn.Tind); err != nil {
+		log.Fatalf("Can't write %s, %s", tindFName, err)
+	}
+	if err := syn.WriteLabels(labelsFName); err != nil {
+		log.Fatalf("Can't write %s, %s", labelsFName, err)
+	}
+	slog.Info("generated", "oclc", len(syn.OCLC), "tind", len(syn.Tind), "labels", len(syn.Labels), runningTime(startT))
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestExportRoundTrip(t *testing.T) {
+	//NOTE: the values hold what troubles CSV, quotes, separators, line breaks and
+	// multi-byte runes. encoding/csv reads a quoted \r\n as \n so it is left out.
+	vals := []string{"", "plain", "a, b", `say "when"`, "two\nlines", " padded ", "tab\there", "é漢;:/"}
+	for _, side := range []string{"oclc", "tind"} {
+		columnNames := oclcColumns
+		if side == "tind" {
+			columnNames = tindColumns
+		}
+		records := []*Record{}
+		for i := range vals {
+			rec := new(Record)
+			for j, cName := range columnNames {
+				rec.SetField(cName, vals[(i+j)%len(vals)])
+			}
+			records = append(records, rec)
+		}
+		fName := filepath.Join(t.TempDir(), side+".csv")
+		if err := WriteExport(fName, columnNames, records); err != nil {
+			t.Fatalf("can't write %s, %s", fName, err)
+		}
+		read, err := loadExport(side, fName, "", "")
+		if err != nil {
+			t.Fatalf("can't read back the %s export, %s", side, err)
+		}
+		if len(read) != len(records) {
+			t.Fatalf("%s export of %d records read back as %d", side, len(records), len(read))
+		}
+		for i := range read {
+			for _, cName := range columnNames {
+				if read[i].Field(cName) != records[i].Field(cName) {
+					t.Errorf("%s %q written %q read back %q", side, cName, records[i].Field(cName), read[i].Field(cName))
+				}
+			}
+		}
+	}
+}
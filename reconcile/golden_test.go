@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/golden from this build's output")
+
+// goldenRuns are the end to end runs compared with testdata/golden/NAME.
+// "@/" in an argument stands for the testdata directory, the outputs
+// are written to a scratch directory.
+var goldenRuns = []struct {
+	name string
+	args []string
+}{
+	{"csv", []string{"-oclc", "@/oclc.csv", "-tind", "@/tind.csv", "-o", "out.csv"}},
+	{"jsonl", []string{"-oclc", "@/oclc.csv", "-tind", "@/tind.csv", "-format", "jsonl", "-o", "out.jsonl"}},
+	{"split", []string{"-oclc", "@/oclc.csv", "-tind", "@/tind.csv",
+		"-matched", "matched.csv", "-ambiguous", "ambiguous.csv", "-unmatched-oclc", "unmatched-oclc.csv", "-unmatched-tind", "unmatched-tind.csv"}},
+	{"assign", []string{"-oclc", "@/oclc.csv", "-tind", "@/tind.csv", "-assign", "-format", "jsonl", "-o", "out.jsonl"}},
+	{"decisions", []string{"-oclc", "@/oclc.csv", "-tind", "@/tind.csv", "-decisions", "@/decisions.csv", "-o", "out.csv"}},
+	{"marcxml", []string{"-oclc", "@/oclc.csv", "-tind", "@/tind.xml", "-o", "out.csv", "-tind-marc-out", "tind-marc.xml"}},
+	{"components", []string{"-oclc", "@/oclc.csv", "-tind", "@/tind.csv", "-o", "out.csv", "-components", "components.jsonl"}},
+	{"evaluate", []string{"evaluate", "-oclc", "@/oclc.csv", "-tind", "@/tind.csv", "-labels", "@/labels.csv", "-o", "evaluation.txt"}},
+	{"tune", []string{"tune", "-oclc", "@/oclc.csv", "-tind", "@/tind.csv", "-labels", "@/labels.csv", "-o", "matcher.json", "-curve", "curve.csv"}},
+	{"dedupe", []string{"dedupe", "-side", "tind", "-tind", "@/tind.csv", "-format", "jsonl", "-o", "clusters.jsonl", "-representatives", "representatives.csv"}},
+	{"generate", []string{"generate", "-n", "20", "-oclc", "oclc.csv", "-tind", "tind.csv", "-labels", "labels.csv"}},
+}
+
+// runMain runs main in dName as the command line args would, putting
+// back the globals a run changes. A run failing with log.Fatal ends
+// the test binary.
+func runMain(t *testing.T, dName string, args []string) {
+	t.Helper()
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	savedArgs, savedCommandLine, savedMatcher, savedLogger := os.Args, flag.CommandLine, matcher, slog.Default()
+	defer func() {
+		os.Args, flag.CommandLine, matcher = savedArgs, savedCommandLine, savedMatcher
+		slog.SetDefault(savedLogger)
+		os.Chdir(cwd)
+	}()
+	if err := os.Chdir(dName); err != nil {
+		t.Fatal(err)
+	}
+	os.Args = append([]string{"reconcile"}, args...)
+	flag.CommandLine = flag.NewFlagSet("reconcile", flag.ExitOnError)
+	main()
+}
+
+func TestGolden(t *testing.T) {
+	testdata, err := filepath.Abs("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, run := range goldenRuns {
+		t.Run(run.name, func(t *testing.T) {
+			dName := t.TempDir()
+			args := []string{}
+			for _, arg := range run.args {
+				args = append(args, strings.Replace(arg, "@/", testdata+string(os.PathSeparator), 1))
+			}
+			runMain(t, dName, append(args, "-quiet"))
+
+			golden := filepath.Join(testdata, "golden", run.name)
+			if *update == true {
+				os.RemoveAll(golden)
+				if err := os.MkdirAll(golden, 0775); err != nil {
+					t.Fatal(err)
+				}
+				files, _ := ioutil.ReadDir(dName)
+				for _, fi := range files {
+					src, err := ioutil.ReadFile(filepath.Join(dName, fi.Name()))
+					if err == nil {
+						err = ioutil.WriteFile(filepath.Join(golden, fi.Name()), src, 0664)
+					}
+					if err != nil {
+						t.Errorf("can't update %s, %s", fi.Name(), err)
+					}
+				}
+				return
+			}
+			files, err := ioutil.ReadDir(golden)
+			if err != nil || len(files) == 0 {
+				t.Fatalf("no golden files in %s, run go test -run TestGolden -update", golden)
+			}
+			for _, fi := range files {
+				want, _ := ioutil.ReadFile(filepath.Join(golden, fi.Name()))
+				got, err := ioutil.ReadFile(filepath.Join(dName, fi.Name()))
+				if err != nil {
+					t.Errorf("%s wasn't written", fi.Name())
+					continue
+				}
+				if bytes.Equal(got, want) == false {
+					t.Errorf("%s differs from the golden file, %s", fi.Name(), firstDifference(want, got))
+				}
+			}
+		})
+	}
+}
+
+// firstDifference describes the first line where got differs from want
+func firstDifference(want, got []byte) string {
+	wantLines, gotLines := strings.Split(string(want), "\n"), strings.Split(string(got), "\n")
+	for i := 0; i < len(wantLines) || i < len(gotLines); i++ {
+		w, g := "", ""
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if w != g {
+			return fmt.Sprintf("line %d\n    want %s\n    got  %s", i+1, w, g)
+		}
+	}
+	return "in line endings"
+}
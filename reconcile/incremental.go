@@ -0,0 +1,252 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Manifest records what a run read and what it linked so the next run
+// can reconcile incrementally. Records are kept as content hashes keyed
+// by id, OCLC number for OCLC records and TIND id for TIND records.
+// Matcher is the hash of the matcher config the links were found with.
+type Manifest struct {
+	Matcher string            `json:"matcher"`
+	OCLC    map[string]string `json:"oclc"`
+	Tind    map[string]string `json:"tind"`
+	Links   []*Link           `json:"links"`
+}
+
+// LoadManifest reads a manifest written by a previous run
+func LoadManifest(fName string) (*Manifest, error) {
+	src, err := ioutil.ReadFile(fName)
+	if err != nil {
+		return nil, err
+	}
+	m := new(Manifest)
+	if err := json.Unmarshal(src, m); err != nil {
+		return nil, fmt.Errorf("%s, %s", fName, err)
+	}
+	return m, nil
+}
+
+// Save writes the manifest to fName
+func (m *Manifest) Save(fName string) error {
+	src, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fName, src, 0664)
+}
+
+// contentHash hashes the fields of rec read from an export
+func contentHash(rec *Record) string {
+	h := sha1.New()
+	for _, val := range []string{rec.MaterialType, rec.MonoOrSerial, rec.Date1, rec.Date2,
+		rec.Form, rec.Tind, rec.OCLC, rec.ISBN, rec.ISSN, rec.Title, rec.SubTitle,
+		rec.Author, rec.Publisher, rec.Year, rec.Pagination} {
+		fmt.Fprintf(h, "%s\x1f", val)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// hashRecords returns the content hashes of recs keyed by idOf, records
+// sharing an id are hashed together. Records without an id are left out.
+func hashRecords(recs []*Record, idOf func(*Record) string) (map[string]string, map[string]int) {
+	hashes := map[string]string{}
+	counts := map[string]int{}
+	for _, rec := range recs {
+		id := idOf(rec)
+		if id == "" {
+			continue
+		}
+		hashes[id] = hashes[id] + contentHash(rec)
+		counts[id]++
+	}
+	for id, val := range hashes {
+		if counts[id] > 1 {
+			hashes[id] = fmt.Sprintf("%x", sha1.Sum([]byte(val)))
+		}
+	}
+	return hashes, counts
+}
+
+// matcherHash hashes the parameters of mc which change what matches
+func matcherHash(mc *MatchConfig) string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(mc.String())))
+}
+
+func oclcID(rec *Record) string { return rec.OCLC }
+func tindID(rec *Record) string { return rec.Tind }
+
+// Manifest returns the manifest of the run
+func (r *run) Manifest() *Manifest {
+	m := new(Manifest)
+	m.Matcher = matcherHash(matcher)
+	m.OCLC, _ = hashRecords(r.oclc, oclcID)
+	m.Tind, _ = hashRecords(r.tind, tindID)
+	m.Links = r.found.pairs
+	return m
+}
+
+// diffHashes returns the ids added to, changed in and deleted from prev
+func diffHashes(prev, cur map[string]string) (map[string]bool, map[string]bool, map[string]bool) {
+	added, changed, deleted := map[string]bool{}, map[string]bool{}, map[string]bool{}
+	for id, hash := range cur {
+		if prevHash, ok := prev[id]; ok == false {
+			added[id] = true
+		} else if prevHash != hash {
+			changed[id] = true
+		}
+	}
+	for id := range prev {
+		if _, ok := cur[id]; ok == false {
+			deleted[id] = true
+		}
+	}
+	return added, changed, deleted
+}
+
+// planIncremental works out which OCLC records need matching again
+// given the manifest of the previous run. An OCLC record is matched
+// again when it is new or changed, when a TIND record it was linked to
+// changed or was deleted, or when it matches a new or changed TIND
+// record. Ids shared by several records are always matched again, as
+// is everything when the previous run used another matcher config. The
+// previous results of all other OCLC records are carried over with the
+// status Assign gave them. Returns the number of OCLC records to match
+// again.
+func (r *run) planIncremental(prev *Manifest) int {
+	r.carried = map[int]*Result{}
+	if prev.Matcher != matcherHash(matcher) {
+		slog.Warn("the previous run used another matcher config, matching every OCLC record again")
+		return len(r.oclc)
+	}
+	oclcHashes, oclcCounts := hashRecords(r.oclc, oclcID)
+	tindHashes, tindCounts := hashRecords(r.tind, tindID)
+	oclcAdded, oclcChanged, oclcDeleted := diffHashes(prev.OCLC, oclcHashes)
+	tindAdded, tindChanged, tindDeleted := diffHashes(prev.Tind, tindHashes)
+	slog.Info("OCLC records changed", "added", len(oclcAdded), "changed", len(oclcChanged), "deleted", len(oclcDeleted))
+	slog.Info("TIND records changed", "added", len(tindAdded), "changed", len(tindChanged), "deleted", len(tindDeleted))
+
+	prevLinks := map[string][]*Link{}
+	for _, l := range prev.Links {
+		prevLinks[l.OCLC] = append(prevLinks[l.OCLC], l)
+	}
+	tindByID := map[string]*Record{}
+	fresh := []*Record{}
+	for _, rec := range r.tind {
+		tindByID[rec.Tind] = rec
+		if rec.Tind == "" || tindAdded[rec.Tind] || tindChanged[rec.Tind] || tindCounts[rec.Tind] > 1 {
+			fresh = append(fresh, rec)
+		}
+	}
+
+	rematch := 0
+	for no, target := range r.oclc {
+		id := target.OCLC
+		affected := id == "" || oclcAdded[id] || oclcChanged[id] || oclcCounts[id] > 1
+		for _, l := range prevLinks[id] {
+			if tindByID[l.Tind] == nil || tindChanged[l.Tind] || tindCounts[l.Tind] > 1 {
+				affected = true
+			}
+		}
+		for _, source := range fresh {
+			if affected {
+				break
+			}
+			affected = Match(target, source, false) || Match(target, source, true)
+		}
+		if affected {
+			rematch++
+			continue
+		}
+		res := &Result{Target: target, Candidates: []*Candidate{}}
+		for _, l := range prevLinks[id] {
+			source := tindByID[l.Tind]
+			res.Candidates = append(res.Candidates, &Candidate{
+				Record: Merge(target, source),
+				Pass:   l.Pass,
+				Score:  Score(target, source),
+				Fields: Compare(target, source),
+				Status: l.Status,
+				source: source,
+			})
+		}
+		for _, c := range res.Candidates {
+			c.Record.MatchedCount = len(res.Candidates)
+		}
+		r.carried[no] = res
+	}
+	return rematch
+}
+
+// linkSets groups the TIND ids linked to each OCLC number, ignoring alternates
+func linkSets(pairs []*Link) map[string][]string {
+	sets := map[string][]string{}
+	for _, l := range pairs {
+		if l.Status != statusAlternate {
+			sets[l.OCLC] = append(sets[l.OCLC], l.Tind)
+		}
+	}
+	for id := range sets {
+		sort.Strings(sets[id])
+	}
+	return sets
+}
+
+// writeDelta writes a CSV report of how the links changed between the
+// previous and current run: a "new link" for an OCLC record now linked
+// which wasn't before, a "broken link" for one no longer linked and a
+// "changed match" when it is linked to different TIND records.
+// Returns the number of rows written.
+func writeDelta(fName string, prev, cur []*Link) (int, error) {
+	fp, err := os.Create(fName)
+	if err != nil {
+		return 0, err
+	}
+	defer fp.Close()
+	w := csv.NewWriter(fp)
+	w.Write([]string{"change", "oclc", "tind", "previous tind"})
+	prevSets, curSets := linkSets(prev), linkSets(cur)
+	ids := []string{}
+	for id := range prevSets {
+		ids = append(ids, id)
+	}
+	for id := range curSets {
+		if _, ok := prevSets[id]; ok == false {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	cnt := 0
+	for _, id := range ids {
+		was, now := prevSets[id], curSets[id]
+		switch {
+		case len(was) == 0:
+			for _, tind := range now {
+				w.Write([]string{"new link", id, tind, ""})
+				cnt++
+			}
+		case len(now) == 0:
+			for _, tind := range was {
+				w.Write([]string{"broken link", id, "", tind})
+				cnt++
+			}
+		case strings.Join(was, ";") != strings.Join(now, ";"):
+			w.Write([]string{"changed match", id, strings.Join(now, ";"), strings.Join(was, ";")})
+			cnt++
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return cnt, err
+	}
+	return cnt, fp.Close()
+}
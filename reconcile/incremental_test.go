@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestPlanIncremental(t *testing.T) {
+	target := testRecord()
+	target.OCLC = "101"
+	first, second := testRecord(), testRecord()
+	first.Tind, second.Tind = "2001", "2002"
+	oclc, tind := []*Record{target}, []*Record{first, second}
+
+	prev := newRun(oclc, tind, nil, new(Checkpoint))
+	prev.found.pairs = []*Link{
+		{OCLC: "101", Tind: "2001", Pass: passExact, Status: statusLinked},
+		{OCLC: "101", Tind: "2002", Pass: passExact, Status: statusAlternate},
+	}
+	manifest := prev.Manifest()
+
+	r := newRun(oclc, tind, nil, new(Checkpoint))
+	if rematch := r.planIncremental(manifest); rematch != 0 {
+		t.Fatalf("unchanged exports, %d records to match again", rematch)
+	}
+	res := r.carried[0]
+	if res == nil || len(res.Candidates) != 2 {
+		t.Fatalf("carried %v, want both candidates", res)
+	}
+	for i, want := range []string{statusLinked, statusAlternate} {
+		if got := res.Candidates[i].Status; got != want {
+			t.Errorf("candidate %s carried as %q, want %q", res.Candidates[i].Record.Tind, got, want)
+		}
+	}
+
+	saved := matcher
+	defer func() { matcher = saved }()
+	matcher = DefaultMatchConfig()
+	matcher.Threshold = 4
+	r = newRun(oclc, tind, nil, new(Checkpoint))
+	if rematch := r.planIncremental(manifest); rematch != len(oclc) || len(r.carried) != 0 {
+		t.Errorf("after a matcher change %d records to match again and %d carried", rematch, len(r.carried))
+	}
+}
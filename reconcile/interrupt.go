@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// defaultCheckpointFName is where an interrupted run without -checkpoint
+// saves its state
+const defaultCheckpointFName = "reconcile.checkpoint"
+
+// interruptible returns a context cancelled by the first interrupt
+// (Ctrl-C) or SIGTERM, a second one kills the program as usual. Call
+// the returned function once the work is done.
+func interruptible() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-sigs:
+			signal.Reset(os.Interrupt, syscall.SIGTERM)
+			slog.Warn("interrupted, saving the results so far, interrupt again to quit at once", "signal", sig.String())
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(sigs)
+		cancel()
+	}
+}
+
+// saveInterrupted saves what an interrupted run has done. The outputs
+// are flushed and a checkpoint written, to -checkpoint or else to
+// defaultCheckpointFName, so the run can be resumed. Runs writing to
+// stdout or assigning links can't be resumed, for them the returned
+// checkpoint name is empty.
+func (r *run) saveInterrupted() (string, error) {
+	stderr.ClearBar()
+	if r.assign == true {
+		if len(r.pending) > 0 {
+			slog.Warn("links are only assigned at the end of a run, the matches found were not written", "matched", len(r.pending))
+		}
+		return "", r.flushStore()
+	}
+	if r.checkpointFName == "" {
+		if r.out.usesStdout == true {
+			return "", r.flushStore()
+		}
+		r.checkpointFName = defaultCheckpointFName
+	}
+	return r.checkpointFName, r.checkpoint()
+}
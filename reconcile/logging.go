@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logOptions are the logging flags shared by the reconcile commands
+type logOptions struct {
+	format  string
+	quiet   bool
+	verbose bool
+}
+
+// addLogFlags adds -log-format, -quiet and -verbose to fs
+func addLogFlags(fs *flag.FlagSet) *logOptions {
+	o := new(logOptions)
+	fs.StringVar(&o.format, "log-format", "plain", "log format, plain, text (logfmt) or json")
+	fs.BoolVar(&o.quiet, "quiet", false, "only log warnings and errors, no progress")
+	fs.BoolVar(&o.verbose, "verbose", false, "also log debugging detail such as each match found")
+	return o
+}
+
+// stderr is where logs and the progress bar are written
+var stderr = &terminalWriter{w: os.Stderr}
+
+// showProgress is true when a progress bar is drawn instead of logging
+// progress at info level
+var showProgress bool
+
+// setup makes the options take effect. Log messages from the log
+// package, which is left to log.Fatal, come through at error level so
+// they are never hidden by -quiet.
+func (o *logOptions) setup() error {
+	level := slog.LevelInfo
+	switch {
+	case o.quiet == true && o.verbose == true:
+		return fmt.Errorf("-quiet and -verbose can't be used together")
+	case o.quiet == true:
+		level = slog.LevelWarn
+	case o.verbose == true:
+		level = slog.LevelDebug
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	switch o.format {
+	case "plain":
+		h = &plainHandler{w: stderr, level: level}
+	case "text":
+		h = slog.NewTextHandler(stderr, opts)
+	case "json":
+		h = slog.NewJSONHandler(stderr, opts)
+	default:
+		return fmt.Errorf("unknown log format %q, use plain, text or json", o.format)
+	}
+	slog.SetDefault(slog.New(h))
+	slog.SetLogLoggerLevel(slog.LevelError)
+	showProgress = o.quiet == false && isTerminal(os.Stderr)
+	return nil
+}
+
+// plainHandler writes log records as the log package does, followed
+// by their attributes as key=value pairs. Groups are flattened.
+type plainHandler struct {
+	w     io.Writer
+	level slog.Level
+	attrs []slog.Attr
+}
+
+func (h *plainHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+// plainValue quotes values with spaces so key=value pairs stay readable
+func plainValue(v slog.Value) string {
+	s := v.Resolve().String()
+	if s == "" || strings.ContainsAny(s, " =\"") {
+		return fmt.Sprintf("%q", s)
+	}
+	return s
+}
+
+func (h *plainHandler) Handle(_ context.Context, rec slog.Record) error {
+	var b strings.Builder
+	b.WriteString(rec.Time.Format("2006/01/02 15:04:05 "))
+	if rec.Level != slog.LevelInfo {
+		b.WriteString(rec.Level.String() + " ")
+	}
+	b.WriteString(rec.Message)
+	for _, a := range h.attrs {
+		fmt.Fprintf(&b, " %s=%s", a.Key, plainValue(a.Value))
+	}
+	rec.Attrs(func(a slog.Attr) bool {
+		fmt.Fprintf(&b, " %s=%s", a.Key, plainValue(a.Value))
+		return true
+	})
+	b.WriteString("\n")
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+func (h *plainHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &plainHandler{w: h.w, level: h.level, attrs: append(append([]slog.Attr{}, h.attrs...), attrs...)}
+}
+
+func (h *plainHandler) WithGroup(name string) slog.Handler {
+	return h
+}
+
+// runningTime is the time since startT as a log attribute
+func runningTime(startT time.Time) slog.Attr {
+	return slog.Duration("running_time", time.Now().Sub(startT))
+}
+
+// terminalWriter keeps a progress bar on the last line of the terminal,
+// log lines are written above it
+type terminalWriter struct {
+	sync.Mutex
+	w   io.Writer
+	bar string
+}
+
+const clearLine = "\r\x1b[K"
+
+func (tw *terminalWriter) Write(p []byte) (int, error) {
+	tw.Lock()
+	defer tw.Unlock()
+	if tw.bar != "" {
+		io.WriteString(tw.w, clearLine)
+	}
+	n, err := tw.w.Write(p)
+	if tw.bar != "" {
+		io.WriteString(tw.w, tw.bar)
+	}
+	return n, err
+}
+
+// Draw replaces the progress bar
+func (tw *terminalWriter) Draw(bar string) {
+	tw.Lock()
+	defer tw.Unlock()
+	tw.bar = bar
+	io.WriteString(tw.w, clearLine+bar)
+}
+
+// ClearBar removes the progress bar
+func (tw *terminalWriter) ClearBar() {
+	tw.Lock()
+	defer tw.Unlock()
+	if tw.bar != "" {
+		io.WriteString(tw.w, clearLine)
+		tw.bar = ""
+	}
+}
+
+// progressBar renders a bar for done of total rows. The ETA assumes the
+// rows still to do take as long as the done rows did in elapsed.
+func progressBar(label string, done, total, doneHere int, elapsed time.Duration) string {
+	const width = 30
+	filled := width
+	if total > 0 {
+		filled = width * done / total
+	}
+	eta := "?"
+	if doneHere > 0 {
+		eta = (elapsed / time.Duration(doneHere) * time.Duration(total-done)).Round(time.Second).String()
+	}
+	return fmt.Sprintf("%s [%s%s] %d/%d %s ETA %s", label,
+		strings.Repeat("=", filled), strings.Repeat(" ", width-filled), done, total, percentage(done, total), eta)
+}
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	marcSubfieldDelimiter = 0x1F
+	marcFieldTerminator   = 0x1E
+	marcRecordTerminator  = 0x1D
+)
+
+// MARCSubfield holds a single subfield code and value of a data field
+type MARCSubfield struct {
+	Code  string
+	Value string
+}
+
+// MARCField holds either a control field (tags 001-009) or a data field
+type MARCField struct {
+	Tag       string
+	Ind1      string
+	Ind2      string
+	Value     string
+	Subfields []*MARCSubfield
+}
+
+// MARCRecord is a minimal representation of a MARC 21 bibliographic record
+type MARCRecord struct {
+	Leader string
+	Fields []*MARCField
+}
+
+// MARCMapping maps our column names (see RowToRecord) to an ordered
+// list of MARC locations. The first location with a value wins.
+//
+// Locations are written as
+//
+//	"001"           control field
+//	"008/07-10"     character positions in a control field
+//	"LDR/06"        character positions in the leader
+//	"245$ab"        subfields of a data field joined with a space
+//	"035$a=(OCoLC)" subfield value starting with a prefix, prefix removed
+type MARCMapping map[string][]string
+
+var (
+	// oclcMARCMapping is the default mapping for OCLC exports
+	oclcMARCMapping = MARCMapping{
+		"material type":  {"LDR/06"},
+		"mono or serial": {"LDR/07"},
+		"date1":          {"008/07-10"},
+		"date2":          {"008/11-14"},
+		"form":           {"008/23"},
+		"oclc":           {"001", "035$a=(OCoLC)"},
+		"isbn":           {"020$a"},
+		"issn":           {"022$a"},
+		"title":          {"245$a"},
+		"subtitle":       {"245$b"},
+		"author":         {"100$a", "110$a"},
+		"publisher":      {"264$b", "260$b"},
+		"year":           {"264$c", "260$c"},
+		"pagination":     {"300$a"},
+	}
+
+	// tindMARCMapping is the default mapping for TIND exports
+	tindMARCMapping = MARCMapping{
+		"material type":  {"LDR/06"},
+		"mono or serial": {"LDR/07"},
+		"date1":          {"008/07-10"},
+		"date2":          {"008/11-14"},
+		"form":           {"008/23"},
+		"tind":           {"001"},
+		"oclc":           {"035$a=(OCoLC)"},
+		"isbn":           {"020$a"},
+		"issn":           {"022$a"},
+		"title":          {"245$a"},
+		"subtitle":       {"245$b"},
+		"author":         {"100$a", "110$a"},
+		"publisher":      {"264$b", "260$b"},
+		"year":           {"264$c", "260$c"},
+		"pagination":     {"300$a"},
+	}
+)
+
+// LoadMARCMapping reads a JSON object of column name to locations and
+// returns a copy of base with those columns replaced. A column mapped to
+// an empty list is dropped.
+func LoadMARCMapping(fName string, base MARCMapping) (MARCMapping, error) {
+	src, err := ioutil.ReadFile(fName)
+	if err != nil {
+		return nil, err
+	}
+	overrides := MARCMapping{}
+	if err := json.Unmarshal(src, &overrides); err != nil {
+		return nil, fmt.Errorf("%s, %s", fName, err)
+	}
+	mapping := MARCMapping{}
+	for cName, locations := range base {
+		mapping[cName] = locations
+	}
+	for cName, locations := range overrides {
+		if len(locations) == 0 {
+			delete(mapping, cName)
+		} else {
+			mapping[cName] = locations
+		}
+	}
+	return mapping, nil
+}
+
+// MARCReader reads MARC 21 records in ISO 2709 (binary) format one at a time
+type MARCReader struct {
+	r *bufio.Reader
+}
+
+// NewMARCReader returns a MARCReader reading from r
+func NewMARCReader(r io.Reader) *MARCReader {
+	return &MARCReader{r: bufio.NewReader(r)}
+}
+
+// Read returns the next record or io.EOF when the input is exhausted
+func (mr *MARCReader) Read() (*MARCRecord, error) {
+	// NOTE: Some exports put a line break between records, skip it.
+	for {
+		b, err := mr.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b != '\n' && b != '\r' {
+			mr.r.UnreadByte()
+			break
+		}
+	}
+	prefix := make([]byte, 5)
+	if _, err := io.ReadFull(mr.r, prefix); err != nil {
+		return nil, fmt.Errorf("can't read record length, %s", err)
+	}
+	recLen, err := strconv.Atoi(string(prefix))
+	if err != nil || recLen < 25 {
+		return nil, fmt.Errorf("bad record length %q", prefix)
+	}
+	buf := make([]byte, recLen)
+	copy(buf, prefix)
+	if _, err := io.ReadFull(mr.r, buf[5:]); err != nil {
+		return nil, fmt.Errorf("truncated record, %s", err)
+	}
+	return ParseMARC(buf)
+}
+
+// ParseMARC decodes a single ISO 2709 record. Character data is passed
+// through as is, MARC-8 encoded records are not converted.
+func ParseMARC(buf []byte) (*MARCRecord, error) {
+	if len(buf) < 25 {
+		return nil, fmt.Errorf("record too short")
+	}
+	rec := new(MARCRecord)
+	rec.Leader = string(buf[0:24])
+	base, err := strconv.Atoi(string(buf[12:17]))
+	if err != nil || base > len(buf) || base < 25 {
+		return nil, fmt.Errorf("bad base address %q", buf[12:17])
+	}
+	directory := buf[24 : base-1]
+	if len(directory)%12 != 0 {
+		return nil, fmt.Errorf("bad directory length %d", len(directory))
+	}
+	for i := 0; i < len(directory); i += 12 {
+		entry := directory[i : i+12]
+		tag := string(entry[0:3])
+		fLen, err := strconv.Atoi(string(entry[3:7]))
+		if err != nil {
+			return nil, fmt.Errorf("bad field length for %s", tag)
+		}
+		start, err := strconv.Atoi(string(entry[7:12]))
+		if err != nil {
+			return nil, fmt.Errorf("bad field start for %s", tag)
+		}
+		if start < 0 || fLen < 0 {
+			return nil, fmt.Errorf("negative field length or start for %s", tag)
+		}
+		if base+start+fLen > len(buf) {
+			return nil, fmt.Errorf("field %s runs past end of record", tag)
+		}
+		data := strings.TrimRight(string(buf[base+start:base+start+fLen]), string([]byte{marcFieldTerminator, marcRecordTerminator}))
+		rec.Fields = append(rec.Fields, parseMARCField(tag, data))
+	}
+	return rec, nil
+}
+
+func isControlTag(tag string) bool {
+	return strings.HasPrefix(tag, "00")
+}
+
+func parseMARCField(tag, data string) *MARCField {
+	field := &MARCField{Tag: tag}
+	if isControlTag(tag) {
+		field.Value = data
+		return field
+	}
+	if len(data) >= 2 {
+		field.Ind1, field.Ind2 = data[0:1], data[1:2]
+		data = data[2:]
+	}
+	for _, part := range strings.Split(data, string([]byte{marcSubfieldDelimiter})) {
+		if len(part) > 0 {
+			field.Subfields = append(field.Subfields, &MARCSubfield{Code: part[0:1], Value: part[1:]})
+		}
+	}
+	return field
+}
+
+// Contains reports if a data field at tag has a subfield code with value
+func (rec *MARCRecord) Contains(tag, code, value string) bool {
+	for _, field := range rec.Fields {
+		if field.Tag != tag {
+			continue
+		}
+		for _, sf := range field.Subfields {
+			if sf.Code == code && strings.TrimSpace(sf.Value) == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Add inserts a data field after the last field with a tag sorting at
+// or before its own, keeping the record in tag order
+func (rec *MARCRecord) Add(field *MARCField) {
+	i := len(rec.Fields)
+	for i > 0 && rec.Fields[i-1].Tag > field.Tag {
+		i--
+	}
+	rec.Fields = append(rec.Fields, nil)
+	copy(rec.Fields[i+1:], rec.Fields[i:])
+	rec.Fields[i] = field
+}
+
+// Lookup returns the value of the first location which yields a non-empty string
+func (rec *MARCRecord) Lookup(locations ...string) string {
+	for _, location := range locations {
+		if val := rec.lookup(location); val != "" {
+			return val
+		}
+	}
+	return ""
+}
+
+func (rec *MARCRecord) lookup(location string) string {
+	tag, codes, prefix := splitLocation(location)
+	// Character positions, e.g. LDR/06 or 008/07-10
+	if i := strings.Index(tag, "/"); i >= 0 {
+		tag, positions := tag[:i], tag[i+1:]
+		val := rec.Leader
+		if tag != "LDR" {
+			val = ""
+			for _, field := range rec.Fields {
+				if field.Tag == tag {
+					val = field.Value
+					break
+				}
+			}
+		}
+		return strings.TrimSpace(charPositions(val, positions))
+	}
+	for _, field := range rec.Fields {
+		if field.Tag != tag {
+			continue
+		}
+		val := field.Value
+		if codes != "" {
+			parts := []string{}
+			for _, sf := range field.Subfields {
+				if strings.Contains(codes, sf.Code) {
+					parts = append(parts, strings.TrimSpace(sf.Value))
+				}
+			}
+			val = strings.Join(parts, " ")
+		}
+		val = strings.TrimSpace(val)
+		if prefix != "" {
+			if strings.HasPrefix(val, prefix) == false {
+				continue
+			}
+			val = strings.TrimSpace(strings.TrimPrefix(val, prefix))
+		}
+		if val != "" {
+			return val
+		}
+	}
+	return ""
+}
+
+// splitLocation breaks a location like "035$a=(OCoLC)" into its tag,
+// subfield codes and prefix
+func splitLocation(location string) (string, string, string) {
+	tag, codes, prefix := location, "", ""
+	if i := strings.Index(tag, "="); i >= 0 {
+		tag, prefix = tag[:i], tag[i+1:]
+	}
+	if i := strings.Index(tag, "$"); i >= 0 {
+		tag, codes = tag[:i], tag[i+1:]
+	}
+	return tag, codes, prefix
+}
+
+// charPositions returns the characters from positions like "06" or "07-10"
+func charPositions(val, positions string) string {
+	start, end := positions, positions
+	if i := strings.Index(positions, "-"); i >= 0 {
+		start, end = positions[:i], positions[i+1:]
+	}
+	s, err := strconv.Atoi(start)
+	if err != nil {
+		return ""
+	}
+	e, err := strconv.Atoi(end)
+	if err != nil || e < s || s >= len(val) {
+		return ""
+	}
+	if e >= len(val) {
+		e = len(val) - 1
+	}
+	return val[s : e+1]
+}
+
+// cleanMARCValue strips the ISBD punctuation catalogers leave at the
+// end of transcribed fields and the qualifiers found in identifiers
+func cleanMARCValue(cName, val string) string {
+	punctuation := " /:;,="
+	if cName == "year" {
+		punctuation += "."
+	}
+	val = trimEnd(val, punctuation)
+	switch cName {
+	case "isbn", "issn":
+		if fields := strings.Fields(val); len(fields) > 0 {
+			// The number may be followed by a qualifier, "0123456789 (pbk.)"
+			val = trimEnd(fields[0], punctuation)
+		}
+	case "oclc":
+		for _, prefix := range []string{"ocm", "ocn", "on"} {
+			if strings.HasPrefix(val, prefix) {
+				val = strings.TrimPrefix(val, prefix)
+				break
+			}
+		}
+		val = strings.TrimLeft(val, "0")
+	}
+	return strings.TrimSpace(val)
+}
+
+// trimEnd strips the spaces and punctuation ending val
+func trimEnd(val, punctuation string) string {
+	return strings.TrimRightFunc(val, func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune(punctuation, r)
+	})
+}
+
+// MARCToRecord maps a MARC record into a Record using mapping
+func MARCToRecord(m *MARCRecord, mapping MARCMapping) *Record {
+	columnNames := []string{}
+	row := []string{}
+	for cName, locations := range mapping {
+		columnNames = append(columnNames, cName)
+		row = append(row, cleanMARCValue(cName, m.Lookup(locations...)))
+	}
+	rec := RowToRecord(columnNames, row)
+	rec.marc = m
+	return rec
+}
+
+// MARCWriter writes MARC 21 records in ISO 2709 (binary) format
+type MARCWriter struct {
+	w io.Writer
+}
+
+// NewMARCWriter returns a MARCWriter writing to w
+func NewMARCWriter(w io.Writer) *MARCWriter {
+	return &MARCWriter{w: w}
+}
+
+// Write encodes rec, recalculating the record length, base address
+// and directory from its fields
+func (mw *MARCWriter) Write(rec *MARCRecord) error {
+	var directory, data bytes.Buffer
+	for _, field := range rec.Fields {
+		start := data.Len()
+		if isControlTag(field.Tag) {
+			data.WriteString(field.Value)
+		} else {
+			for _, ind := range []string{field.Ind1, field.Ind2} {
+				if ind == "" {
+					ind = " "
+				}
+				data.WriteString(ind)
+			}
+			for _, sf := range field.Subfields {
+				data.WriteByte(marcSubfieldDelimiter)
+				data.WriteString(sf.Code)
+				data.WriteString(sf.Value)
+			}
+		}
+		data.WriteByte(marcFieldTerminator)
+		// The directory holds a field's length in four digits
+		if data.Len()-start > 9999 {
+			return fmt.Errorf("field %s too long, %d bytes", field.Tag, data.Len()-start)
+		}
+		fmt.Fprintf(&directory, "%3s%04d%05d", field.Tag, data.Len()-start, start)
+	}
+	directory.WriteByte(marcFieldTerminator)
+	data.WriteByte(marcRecordTerminator)
+	base := 24 + directory.Len()
+	total := base + data.Len()
+	if total > 99999 {
+		return fmt.Errorf("record too long, %d bytes", total)
+	}
+	leader := []byte(fmt.Sprintf("%-24s", rec.Leader))[0:24]
+	copy(leader[0:5], fmt.Sprintf("%05d", total))
+	copy(leader[10:12], "22")
+	copy(leader[12:17], fmt.Sprintf("%05d", base))
+	copy(leader[20:24], "4500")
+	for _, b := range [][]byte{leader, directory.Bytes(), data.Bytes()} {
+		if _, err := mw.w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close is a no-op, binary records need no trailer
+func (mw *MARCWriter) Close() error {
+	return nil
+}
+
+// marcRecordReader is satisfied by MARCReader and MARCXMLReader
+type marcRecordReader interface {
+	Read() (*MARCRecord, error)
+}
+
+// mkMARCRecords reads all the remaining records from mr
+func mkMARCRecords(mr marcRecordReader, mapping MARCMapping) ([]*Record, error) {
+	records := []*Record{}
+	for i := 1; ; i++ {
+		m, err := mr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("record %d, %s", i, err)
+		}
+		records = append(records, MARCToRecord(m, mapping))
+	}
+	return records, nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCleanMARCValue(t *testing.T) {
+	for _, tc := range []struct {
+		cName, val, want string
+	}{
+		{"title", "A title /", "A title"},
+		{"title", "A title :", "A title"},
+		{"subtitle", "sub ;", "sub"},
+		{"publisher", "Pub,", "Pub"},
+		{"isbn", "0123456789 (pbk.)", "0123456789"},
+		{"isbn", " 9780201500646 ", "9780201500646"},
+		{"issn", "0012-3456 ;", "0012-3456"},
+		{"oclc", "ocm00012345", "12345"},
+		{"oclc", "ocn123456789", "123456789"},
+		{"oclc", "on1234567890", "1234567890"},
+		{"oclc", "000777", "777"},
+		{"year", "1985.", "1985"},
+		{"year", "c1985.", "c1985"},
+		{"pagination", "xii, 300 p. ;", "xii, 300 p."},
+		{"isbn", "0123456789: (pbk.)", "0123456789"},
+		{"issn", "0012-3456, 0012-3457", "0012-3456"},
+		{"year", "1985 ;.", "1985"},
+	} {
+		if got := cleanMARCValue(tc.cName, tc.val); got != tc.want {
+			t.Errorf("cleanMARCValue(%q, %q) = %q, want %q", tc.cName, tc.val, got, tc.want)
+		}
+	}
+}
+
+func FuzzCleanMARCValue(f *testing.F) {
+	for _, val := range []string{"", "A title /", "0123456789 (pbk.)", "ocm00012345", "1985.", " ; ", "0, 0", "1985;."} {
+		f.Add(val)
+	}
+	f.Fuzz(func(t *testing.T, val string) {
+		for _, cName := range []string{"title", "isbn", "issn", "oclc", "year"} {
+			once := cleanMARCValue(cName, val)
+			if once != strings.TrimSpace(once) {
+				t.Errorf("cleanMARCValue(%q, %q) = %q keeps spaces", cName, val, once)
+			}
+			//NOTE: "ocm0ocm1" cleans to "ocm1" which cleans again to "1", OCLC numbers aren't idempotent
+			if twice := cleanMARCValue(cName, once); cName != "oclc" && twice != once {
+				t.Errorf("cleanMARCValue(%q, %q) = %q changes again to %q", cName, val, once, twice)
+			}
+		}
+	})
+}
+
+// testMARC returns a MARC 21 binary record holding a control field and a title
+func testMARC(t testing.TB) []byte {
+	buf := new(bytes.Buffer)
+	mw := NewMARCWriter(buf)
+	rec := &MARCRecord{Leader: "00000nam a2200000   4500", Fields: []*MARCField{
+		{Tag: "001", Value: "2001"},
+		{Tag: "245", Ind1: "1", Ind2: "0", Subfields: []*MARCSubfield{{Code: "a", Value: "The nature of light"}}},
+	}}
+	if err := mw.Write(rec); err != nil {
+		t.Fatal(err)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestParseMARC(t *testing.T) {
+	src := testMARC(t)
+	rec, err := ParseMARC(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rec.Lookup("245$a"); got != "The nature of light" {
+		t.Errorf("245$a read as %q", got)
+	}
+	// The first directory entry starts at 24, a tag then four digits of
+	// length and five of start
+	for _, tc := range []struct {
+		name  string
+		at    int
+		value string
+	}{
+		{"negative length", 27, "-001"},
+		{"negative start", 31, "-0001"},
+		{"length past the end", 27, "9999"},
+		{"length not a number", 27, "00x5"},
+	} {
+		bad := append([]byte{}, src...)
+		copy(bad[tc.at:], tc.value)
+		if _, err := ParseMARC(bad); err == nil {
+			t.Errorf("%s: ParseMARC accepted the record", tc.name)
+		}
+	}
+}
+
+func FuzzParseMARC(f *testing.F) {
+	f.Add(testMARC(f))
+	f.Fuzz(func(t *testing.T, src []byte) {
+		ParseMARC(src)
+	})
+}
+
+func TestMARCWriterFieldLength(t *testing.T) {
+	for _, tc := range []struct {
+		size    int
+		wantErr bool
+	}{
+		//NOTE: the field also holds its indicators, the subfield code and delimiter and its terminator
+		{9994, false},
+		{9995, true},
+	} {
+		rec := &MARCRecord{Leader: "00000nam a2200000   4500", Fields: []*MARCField{
+			{Tag: "001", Value: "2001"},
+			{Tag: "520", Ind1: " ", Ind2: " ", Subfields: []*MARCSubfield{{Code: "a", Value: strings.Repeat("x", tc.size)}}},
+		}}
+		buf := new(bytes.Buffer)
+		err := NewMARCWriter(buf).Write(rec)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%d byte subfield: Write error %v, want an error %t", tc.size, err, tc.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if _, err := ParseMARC(buf.Bytes()); err != nil {
+			t.Errorf("%d byte subfield: written record doesn't parse, %s", tc.size, err)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// marcRecordWriter is satisfied by MARCWriter and MARCXMLWriter
+type marcRecordWriter interface {
+	Write(*MARCRecord) error
+	Close() error
+}
+
+// writeMARCWithIdentifiers writes the MARC records behind recs which
+// gained an identifier at location (e.g. "035$a=(OCoLC)"). idOf returns
+// the identifier a record was matched to, or an empty string, and the
+// identifier it held as read and cleaned, so an 035 of
+// "(OCoLC)ocm00000112" holds 112 though the field written,
+// "(OCoLC)112", isn't in the record. Records read from CSV and records
+// already holding the identifier are skipped, as are records holding a
+// different one, which are counted as conflicts rather than given a
+// second. Returns the number of records written and of conflicts.
+func writeMARCWithIdentifiers(fName, format string, recs []*Record, location string, idOf func(*Record) (string, string)) (int, int, error) {
+	tag, codes, prefix := splitLocation(location)
+	if len(tag) != 3 || isControlTag(tag) || len(codes) != 1 {
+		return 0, 0, fmt.Errorf("identifier location %q must name one subfield of a data field", location)
+	}
+	fp, err := os.Create(fName)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer fp.Close()
+	var mw marcRecordWriter
+	switch format {
+	case "marc":
+		mw = NewMARCWriter(fp)
+	case "marcxml":
+		mw = NewMARCXMLWriter(fp)
+	default:
+		return 0, 0, fmt.Errorf("can't write MARC records as %q", format)
+	}
+	cnt, conflicts := 0, 0
+	for _, rec := range recs {
+		id, held := idOf(rec)
+		if rec.marc == nil || id == "" || id == held || rec.marc.Contains(tag, codes, prefix+id) {
+			continue
+		}
+		if held != "" {
+			slog.Debug("record holds a different identifier, not written", "location", location, "held", held, "matched", id)
+			conflicts++
+			continue
+		}
+		rec.marc.Add(&MARCField{
+			Tag:       tag,
+			Ind1:      " ",
+			Ind2:      " ",
+			Subfields: []*MARCSubfield{{Code: codes, Value: prefix + id}},
+		})
+		if err := mw.Write(rec.marc); err != nil {
+			return cnt, conflicts, err
+		}
+		cnt++
+	}
+	if err := mw.Close(); err != nil {
+		return cnt, conflicts, err
+	}
+	return cnt, conflicts, fp.Close()
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteMARCWithIdentifiers(t *testing.T) {
+	//NOTE: 2012 holds its OCLC number as (OCoLC)ocm00000112, 2001 has none
+	for _, tc := range []struct {
+		name       string
+		oclcByTind map[string]string
+		written    int
+		conflicts  int
+	}{
+		{"number held another way", map[string]string{"2001": "101", "2012": "112"}, 1, 0},
+		{"different number held", map[string]string{"2001": "101", "2012": "113"}, 1, 1},
+		{"nothing matched", map[string]string{}, 0, 0},
+	} {
+		//NOTE: writing adds the 035 to the records read, each case reads them afresh
+		tind, err := loadExport("tind", filepath.Join("testdata", "tind.xml"), "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cnt, conflicts, err := writeMARCWithIdentifiers(filepath.Join(t.TempDir(), "tind.xml"), "marcxml", tind, "035$a=(OCoLC)", func(rec *Record) (string, string) {
+			return tc.oclcByTind[rec.Tind], rec.OCLC
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cnt != tc.written || conflicts != tc.conflicts {
+			t.Errorf("%s: wrote %d records with %d conflicts, want %d with %d", tc.name, cnt, conflicts, tc.written, tc.conflicts)
+		}
+	}
+}
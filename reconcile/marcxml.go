@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+const marcXMLNamespace = "http://www.loc.gov/MARC21/slim"
+
+type xmlSubfield struct {
+	Code  string `xml:"code,attr"`
+	Value string `xml:",chardata"`
+}
+
+type xmlControlField struct {
+	Tag   string `xml:"tag,attr"`
+	Value string `xml:",chardata"`
+}
+
+type xmlDataField struct {
+	Tag       string         `xml:"tag,attr"`
+	Ind1      string         `xml:"ind1,attr"`
+	Ind2      string         `xml:"ind2,attr"`
+	Subfields []*xmlSubfield `xml:"subfield"`
+}
+
+type xmlRecord struct {
+	XMLName       xml.Name           `xml:"record"`
+	Leader        string             `xml:"leader"`
+	ControlFields []*xmlControlField `xml:"controlfield"`
+	DataFields    []*xmlDataField    `xml:"datafield"`
+}
+
+// MARCXMLReader reads MARCXML records one at a time so large
+// <collection> documents never need to be held in memory whole
+type MARCXMLReader struct {
+	d *xml.Decoder
+}
+
+// NewMARCXMLReader returns a MARCXMLReader reading from r
+func NewMARCXMLReader(r io.Reader) *MARCXMLReader {
+	return &MARCXMLReader{d: xml.NewDecoder(r)}
+}
+
+// Read returns the next <record> or io.EOF when the document is exhausted
+func (mr *MARCXMLReader) Read() (*MARCRecord, error) {
+	for {
+		tok, err := mr.d.Token()
+		if err != nil {
+			return nil, err
+		}
+		if elem, ok := tok.(xml.StartElement); ok == true && elem.Name.Local == "record" {
+			x := new(xmlRecord)
+			if err := mr.d.DecodeElement(x, &elem); err != nil {
+				return nil, err
+			}
+			return x.toMARC(), nil
+		}
+	}
+}
+
+// toMARC keeps the field order of the document, control fields first
+// as MARCXML requires
+func (x *xmlRecord) toMARC() *MARCRecord {
+	rec := &MARCRecord{Leader: x.Leader}
+	for _, cf := range x.ControlFields {
+		rec.Fields = append(rec.Fields, &MARCField{Tag: cf.Tag, Value: cf.Value})
+	}
+	for _, df := range x.DataFields {
+		field := &MARCField{Tag: df.Tag, Ind1: df.Ind1, Ind2: df.Ind2}
+		for _, sf := range df.Subfields {
+			field.Subfields = append(field.Subfields, &MARCSubfield{Code: sf.Code, Value: sf.Value})
+		}
+		rec.Fields = append(rec.Fields, field)
+	}
+	return rec
+}
+
+// fromMARC is the inverse of toMARC
+func fromMARC(rec *MARCRecord) *xmlRecord {
+	x := &xmlRecord{Leader: rec.Leader}
+	for _, field := range rec.Fields {
+		if isControlTag(field.Tag) {
+			x.ControlFields = append(x.ControlFields, &xmlControlField{Tag: field.Tag, Value: field.Value})
+			continue
+		}
+		df := &xmlDataField{Tag: field.Tag, Ind1: field.Ind1, Ind2: field.Ind2}
+		for _, sf := range field.Subfields {
+			df.Subfields = append(df.Subfields, &xmlSubfield{Code: sf.Code, Value: sf.Value})
+		}
+		x.DataFields = append(x.DataFields, df)
+	}
+	return x
+}
+
+// MARCXMLWriter writes records into a MARCXML <collection>
+type MARCXMLWriter struct {
+	w       io.Writer
+	started bool
+}
+
+// NewMARCXMLWriter returns a MARCXMLWriter writing to w
+func NewMARCXMLWriter(w io.Writer) *MARCXMLWriter {
+	return &MARCXMLWriter{w: w}
+}
+
+func (mw *MARCXMLWriter) start() error {
+	if mw.started {
+		return nil
+	}
+	mw.started = true
+	_, err := fmt.Fprintf(mw.w, "%s<collection xmlns=%q>\n", xml.Header, marcXMLNamespace)
+	return err
+}
+
+// Write appends rec to the collection
+func (mw *MARCXMLWriter) Write(rec *MARCRecord) error {
+	if err := mw.start(); err != nil {
+		return err
+	}
+	src, err := xml.Marshal(fromMARC(rec))
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(mw.w, "%s\n", src)
+	return err
+}
+
+// Close ends the collection
+func (mw *MARCXMLWriter) Close() error {
+	if err := mw.start(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintln(mw.w, "</collection>")
+	return err
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// MatchConfig holds the parameters of Match. DefaultMatchConfig is
+// the matcher as it has always been, "reconcile tune" searches for
+// better ones over labeled pairs.
+type MatchConfig struct {
+	// Threshold is the weighted score of agreeing fields a pair must
+	// exceed before its titles are compared
+	Threshold int `json:"threshold"`
+	// Levenshtein is the greatest title distance the Levenshtein pass accepts
+	Levenshtein int `json:"levenshtein"`
+	// Similarity, when above zero, lets the Levenshtein pass also accept
+	// titles at least this similar (one less the distance over the
+	// length of the longer title) whatever their distance
+	Similarity float64 `json:"similarity"`
+	// Weights of the fields Score counts, fields not listed weigh one
+	Weights map[string]int `json:"weights,omitempty"`
+}
+
+// scoreFields are the fields Score weighs, named as in Compare
+var scoreFields = []string{"material_type", "mono_or_serial", "date1", "date2", "form", "isbn", "issn", "publisher", "year"}
+
+// matcher is the config Score, MatchPass and Scan use, -config replaces it
+var matcher = DefaultMatchConfig()
+
+// DefaultMatchConfig returns the config matching on more than five of
+// the nine fields and titles at most one edit apart
+func DefaultMatchConfig() *MatchConfig {
+	return &MatchConfig{Threshold: 5, Levenshtein: 1}
+}
+
+// LoadMatchConfig reads a config saved by "reconcile tune", parameters
+// the file doesn't give keep their defaults
+func LoadMatchConfig(fName string) (*MatchConfig, error) {
+	src, err := ioutil.ReadFile(fName)
+	if err != nil {
+		return nil, err
+	}
+	mc := DefaultMatchConfig()
+	if err := json.Unmarshal(src, mc); err != nil {
+		return nil, fmt.Errorf("%s, %s", fName, err)
+	}
+	for field := range mc.Weights {
+		if isScoreField(field) == false {
+			return nil, fmt.Errorf("%s, can't weigh %q, use one of %s", fName, field, strings.Join(scoreFields, ", "))
+		}
+	}
+	return mc, nil
+}
+
+// Save writes mc as JSON for LoadMatchConfig
+func (mc *MatchConfig) Save(fName string) error {
+	src, err := json.MarshalIndent(mc, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fName, append(src, '\n'), 0664)
+}
+
+func isScoreField(field string) bool {
+	for _, name := range scoreFields {
+		if name == field {
+			return true
+		}
+	}
+	return false
+}
+
+// weight returns the weight of a field Score counts
+func (mc *MatchConfig) weight(field string) int {
+	if w, ok := mc.Weights[field]; ok == true {
+		return w
+	}
+	return 1
+}
+
+// agreement returns which of scoreFields agree between target and
+// source, bit i set for scoreFields[i]
+func agreement(target, source *Record) uint16 {
+	agree := uint16(0)
+	for i, ok := range []bool{
+		target.MaterialType == source.MaterialType,
+		target.MonoOrSerial == source.MonoOrSerial,
+		target.Date1 == source.Date1,
+		target.Date2 == source.Date2,
+		target.Form == source.Form,
+		target.ISBN == source.ISBN,
+		target.ISSN == source.ISSN,
+		target.Publisher == source.Publisher,
+		target.Year == source.Year,
+	} {
+		if ok == true {
+			agree |= 1 << uint(i)
+		}
+	}
+	return agree
+}
+
+// weigh totals the weights of the fields set in an agreement
+func (mc *MatchConfig) weigh(agree uint16) int {
+	total := 0
+	for i, field := range scoreFields {
+		if agree&(1<<uint(i)) != 0 {
+			total += mc.weight(field)
+		}
+	}
+	return total
+}
+
+// Score is Score using mc's weights
+func (mc *MatchConfig) Score(target, source *Record) int {
+	return mc.weigh(agreement(target, source))
+}
+
+// similarity turns the distance d between titles a and b into one less
+// d over the length of the longer title
+func similarity(d int, a, b string) float64 {
+	longest := len([]rune(a))
+	if l := len([]rune(b)); l > longest {
+		longest = l
+	}
+	if longest == 0 {
+		return 0
+	}
+	if d > longest {
+		d = longest
+	}
+	return 1 - float64(d)/float64(longest)
+}
+
+// String describes mc on one line, weights other than one only
+func (mc *MatchConfig) String() string {
+	return fmt.Sprintf("threshold=%d levenshtein=%d similarity=%g weights=%s", mc.Threshold, mc.Levenshtein, mc.Similarity, mc.weightsString())
+}
+
+// weightsString lists the weights other than one as field=weight;...
+func (mc *MatchConfig) weightsString() string {
+	parts := []string{}
+	for field, w := range mc.Weights {
+		if w != 1 {
+			parts = append(parts, fmt.Sprintf("%s=%d", field, w))
+		}
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ";")
+}
+
+// copy returns a copy of mc which can be changed without changing mc
+func (mc *MatchConfig) copy() *MatchConfig {
+	c := *mc
+	c.Weights = map[string]int{}
+	for field, w := range mc.Weights {
+		c.Weights[field] = w
+	}
+	return &c
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+// randomRecord returns a record with every field up to three runes drawn from alphabet
+func randomRecord(rng *rand.Rand, alphabet string) *Record {
+	runes := []rune(alphabet)
+	rec := new(Record)
+	for _, cName := range tindColumns {
+		val := make([]rune, rng.Intn(4))
+		for i := range val {
+			val[i] = runes[rng.Intn(len(runes))]
+		}
+		rec.SetField(cName, string(val))
+	}
+	return rec
+}
+
+func TestDefaultScore(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		target, source := randomRecord(rng, "ab"), randomRecord(rng, "ab")
+		want := countTrue((target.MaterialType == source.MaterialType), (target.MonoOrSerial == source.MonoOrSerial),
+			(target.Date1 == source.Date1), (target.Date2 == source.Date2), (target.Form == source.Form),
+			(target.ISBN == source.ISBN), (target.ISSN == source.ISSN), (target.Publisher == source.Publisher),
+			(target.Year == source.Year))
+		if got := DefaultMatchConfig().Score(target, source); got != want {
+			t.Fatalf("default Score %d, want %d for %+v and %+v", got, want, target, source)
+		}
+	}
+}
+
+func TestLoadMatchConfig(t *testing.T) {
+	fName := filepath.Join(t.TempDir(), "matcher.json")
+	mc := &MatchConfig{Threshold: 6, Levenshtein: 2, Similarity: 0.9, Weights: map[string]int{"isbn": 3}}
+	if err := mc.Save(fName); err != nil {
+		t.Fatalf("can't save a config, %s", err)
+	}
+	loaded, err := LoadMatchConfig(fName)
+	if err != nil {
+		t.Fatalf("can't load a saved config, %s", err)
+	}
+	if loaded.String() != mc.String() {
+		t.Errorf("config loaded as %s, saved %s", loaded, mc)
+	}
+	for _, tc := range []struct {
+		src string
+		ok  bool
+	}{
+		{`{"threshold": 4}`, true},
+		{`{"weights": {"year": 2}}`, true},
+		{`{"weights": {"title": 2}}`, false},
+		{`{"threshold": "four"}`, false},
+	} {
+		ioutil.WriteFile(fName, []byte(tc.src), 0664)
+		loaded, err := LoadMatchConfig(fName)
+		if (err == nil) != tc.ok {
+			t.Errorf("LoadMatchConfig(%s) error %v", tc.src, err)
+			continue
+		}
+		if err == nil && loaded.Levenshtein != 1 {
+			t.Errorf("LoadMatchConfig(%s) didn't keep the default Levenshtein distance", tc.src)
+		}
+	}
+}
+
+func TestWeightedMatchPass(t *testing.T) {
+	weighted := &MatchConfig{Threshold: 5, Levenshtein: 1, Weights: map[string]int{"isbn": 0, "year": 3}}
+	target, source := testRecord(), testRecord()
+	if got := weighted.Score(target, source); got != 10 {
+		t.Errorf("weighted Score %d, want 10", got)
+	}
+	source.Title = "The nature of lihgt"
+	if weighted.MatchPass(target, source, true) != "" {
+		t.Errorf("two edits matched without a similarity cutoff")
+	}
+	weighted.Similarity = 0.8
+	if weighted.MatchPass(target, source, true) != passLevenshtein {
+		t.Errorf("two edits of 19 letters didn't pass a 0.8 cutoff")
+	}
+}
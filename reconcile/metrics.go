@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// metrics are the counters and gauges of a run served at /metrics in
+// the Prometheus text format. A nil *metrics records nothing so the run
+// needn't check if metrics were asked for.
+type metrics struct {
+	sync.Mutex
+	startT time.Time
+
+	loaded   map[string]int
+	compared int64
+	matches  map[string]int64
+
+	matched       int
+	unmatched     int
+	unmatchedTind int
+
+	phase         string
+	phaseT        time.Time
+	phaseCompared int64
+	rows          int
+	total         int
+	phases        map[string]float64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		startT:  time.Now(),
+		loaded:  map[string]int{},
+		matches: map[string]int64{},
+		phases:  map[string]float64{},
+		phaseT:  time.Now(),
+	}
+}
+
+// Loaded notes the number of records read for side, "oclc" or "tind"
+func (m *metrics) Loaded(side string, cnt int) {
+	if m == nil {
+		return
+	}
+	m.Lock()
+	defer m.Unlock()
+	m.loaded[side] = cnt
+}
+
+// Compared counts the pairs Scan compared
+func (m *metrics) Compared(cnt int) {
+	if m == nil {
+		return
+	}
+	m.Lock()
+	defer m.Unlock()
+	m.compared += int64(cnt)
+	m.phaseCompared += int64(cnt)
+}
+
+// Matched counts a candidate pair output by the run
+func (m *metrics) Matched(pass string) {
+	if m == nil {
+		return
+	}
+	m.Lock()
+	defer m.Unlock()
+	m.matches[pass]++
+}
+
+// Progress notes the position in the current phase and the counts so far
+func (m *metrics) Progress(rows, total, matched, unmatched, unmatchedTind int) {
+	if m == nil {
+		return
+	}
+	m.Lock()
+	defer m.Unlock()
+	m.rows, m.total = rows, total
+	m.matched, m.unmatched, m.unmatchedTind = matched, unmatched, unmatchedTind
+}
+
+// Phase notes a new phase starting, the time of the one before is kept
+func (m *metrics) Phase(name string) {
+	if m == nil {
+		return
+	}
+	m.Lock()
+	defer m.Unlock()
+	t := time.Now()
+	if m.phase != "" {
+		m.phases[m.phase] = t.Sub(m.phaseT).Seconds()
+	}
+	m.phase, m.phaseT, m.phaseCompared = name, t, 0
+	m.rows, m.total = 0, 0
+}
+
+// writeMetric writes one metric family, samples are keyed by their
+// label set (e.g. `pass="exact"`) with "" for a sample without labels
+func writeMetric(w io.Writer, name, kind, help string, samples map[string]float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	labels := []string{}
+	for label := range samples {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		if label == "" {
+			fmt.Fprintf(w, "%s %s\n", name, strconv.FormatFloat(samples[label], 'f', -1, 64))
+		} else {
+			fmt.Fprintf(w, "%s{%s} %s\n", name, label, strconv.FormatFloat(samples[label], 'f', -1, 64))
+		}
+	}
+}
+
+// Expose writes the metrics in the Prometheus text exposition format
+func (m *metrics) Expose(w io.Writer) {
+	m.Lock()
+	defer m.Unlock()
+	t := time.Now()
+	loaded := map[string]float64{}
+	for side, cnt := range m.loaded {
+		loaded[fmt.Sprintf("side=%q", side)] = float64(cnt)
+	}
+	matches := map[string]float64{}
+	for pass, cnt := range m.matches {
+		matches[fmt.Sprintf("pass=%q", pass)] = float64(cnt)
+	}
+	phases := map[string]float64{}
+	for phase, seconds := range m.phases {
+		phases[fmt.Sprintf("phase=%q", phase)] = seconds
+	}
+	rate := 0.0
+	if m.phase != "" {
+		// The phase running has taken as long as it has so far
+		phases[fmt.Sprintf("phase=%q", m.phase)] = t.Sub(m.phaseT).Seconds()
+		if elapsed := t.Sub(m.phaseT).Seconds(); elapsed > 0 {
+			rate = float64(m.phaseCompared) / elapsed
+		}
+	}
+	current := map[string]float64{}
+	for _, name := range phaseNames {
+		val := 0.0
+		if name == m.phase {
+			val = 1
+		}
+		current[fmt.Sprintf("phase=%q", name)] = val
+	}
+
+	writeMetric(w, "reconcile_records_loaded", "gauge", "Records read from each export.", loaded)
+	writeMetric(w, "reconcile_pairs_compared_total", "counter", "OCLC/TIND pairs compared by Match.", map[string]float64{"": float64(m.compared)})
+	writeMetric(w, "reconcile_comparisons_per_second", "gauge", "Pairs compared per second in the current phase.", map[string]float64{"": rate})
+	writeMetric(w, "reconcile_matches_total", "counter", "Candidate pairs output, by the pass which matched them.", matches)
+	writeMetric(w, "reconcile_matched_records", "gauge", "OCLC records with at least one candidate.", map[string]float64{"": float64(m.matched)})
+	writeMetric(w, "reconcile_unmatched_records", "gauge", "OCLC records without a candidate in the current phase.", map[string]float64{"": float64(m.unmatched)})
+	writeMetric(w, "reconcile_unmatched_tind_records", "gauge", "TIND records no OCLC record has matched yet.", map[string]float64{"": float64(m.unmatchedTind)})
+	writeMetric(w, "reconcile_phase", "gauge", "The phase running, 1 for the current phase.", current)
+	writeMetric(w, "reconcile_phase_rows", "gauge", "Rows done in the current phase.", map[string]float64{"": float64(m.rows)})
+	writeMetric(w, "reconcile_phase_rows_total", "gauge", "Rows in the current phase.", map[string]float64{"": float64(m.total)})
+	writeMetric(w, "reconcile_phase_duration_seconds", "gauge", "Time taken by each phase, so far for the current one.", phases)
+	writeMetric(w, "reconcile_running_seconds", "gauge", "Time since the run started.", map[string]float64{"": t.Sub(m.startT).Seconds()})
+}
+
+// serveMetrics serves m at http://addr/metrics while the run goes on
+func serveMetrics(addr string, m *metrics) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		m.Expose(w)
+	})
+	go func() {
+		if err := http.Serve(ln, mux); err != nil {
+			slog.Error("metrics server stopped", "error", err)
+		}
+	}()
+	slog.Info("serving metrics", "url", "http://"+ln.Addr().String()+"/metrics")
+	return nil
+}
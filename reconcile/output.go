@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Result is the outcome of reconciling one target
+type Result struct {
+	Target     *Record      `json:"target"`
+	Candidates []*Candidate `json:"candidates"`
+}
+
+// resultWriter renders results in one of our output formats
+type resultWriter interface {
+	Write(res *Result) error
+}
+
+// newResultWriter returns a writer for format, csv or jsonl. The CSV
+// header is skipped when appending to existing output.
+func newResultWriter(w io.Writer, format string, appending bool) (resultWriter, error) {
+	switch format {
+	case "csv":
+		if appending == false {
+			if _, err := fmt.Fprintln(w, new(Record).Header()); err != nil {
+				return nil, err
+			}
+		}
+		return &csvResultWriter{w: w}, nil
+	case "jsonl":
+		return &jsonlResultWriter{w: w}, nil
+	}
+	return nil, fmt.Errorf("unsupported output format %q", format)
+}
+
+// csvResultWriter writes one row per candidate, or the target itself
+// with a matched count of zero when there are no candidates
+type csvResultWriter struct {
+	w io.Writer
+}
+
+func (cw *csvResultWriter) Write(res *Result) error {
+	if len(res.Candidates) == 0 {
+		res.Target.MatchedCount = 0
+		_, err := fmt.Fprintln(cw.w, res.Target.String())
+		return err
+	}
+	for _, c := range res.Candidates {
+		if _, err := fmt.Fprintln(cw.w, c.Record.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// jsonlResultWriter writes one JSON object per target per line
+type jsonlResultWriter struct {
+	w io.Writer
+}
+
+func (jw *jsonlResultWriter) Write(res *Result) error {
+	src, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(jw.w, "%s\n", src)
+	return err
+}
+
+// outputs routes each result to the stream for its kind. Streams
+// without a file name go to stdout, streams naming the same file share it.
+type outputs struct {
+	matched       resultWriter
+	ambiguous     resultWriter
+	unmatchedOCLC resultWriter
+	unmatchedTind resultWriter
+	buffers       []*bufio.Writer
+	files         []*os.File
+	names         []string
+	usesStdout    bool
+}
+
+// openOutputs creates the files for one-to-one matches, one-to-many
+// matches, OCLC records with no match and TIND records with no match.
+// The unmatched TIND records are only written when unmatchedTind names
+// a file. When resuming, offsets holds the size each file had at the
+// checkpoint, the files are cut back to it and appended to.
+func openOutputs(format, matched, ambiguous, unmatchedOCLC, unmatchedTind string, offsets map[string]int64) (*outputs, error) {
+	o := new(outputs)
+	writers := map[string]resultWriter{}
+	open := func(fName string) (resultWriter, error) {
+		if w, ok := writers[fName]; ok == true {
+			return w, nil
+		}
+		var dest io.Writer = os.Stdout
+		appending := false
+		if fName == "" {
+			o.usesStdout = true
+		} else {
+			fp, err := os.OpenFile(fName, os.O_RDWR|os.O_CREATE, 0664)
+			if err != nil {
+				return nil, err
+			}
+			offset := offsets[fName]
+			if err := fp.Truncate(offset); err != nil {
+				return nil, err
+			}
+			if _, err := fp.Seek(offset, io.SeekStart); err != nil {
+				return nil, err
+			}
+			appending = offset > 0
+			buf := bufio.NewWriter(fp)
+			o.files = append(o.files, fp)
+			o.buffers = append(o.buffers, buf)
+			o.names = append(o.names, fName)
+			dest = buf
+		}
+		w, err := newResultWriter(dest, format, appending)
+		if err != nil {
+			return nil, err
+		}
+		writers[fName] = w
+		return w, nil
+	}
+	var err error
+	if o.matched, err = open(matched); err != nil {
+		return nil, err
+	}
+	if o.ambiguous, err = open(ambiguous); err != nil {
+		return nil, err
+	}
+	if o.unmatchedOCLC, err = open(unmatchedOCLC); err != nil {
+		return nil, err
+	}
+	if unmatchedTind != "" {
+		if o.unmatchedTind, err = open(unmatchedTind); err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+// Write sends res to the matched, ambiguous or unmatched OCLC stream.
+// After Assign the linked candidate goes to the matched stream and any
+// alternates to the ambiguous stream.
+func (o *outputs) Write(res *Result) error {
+	if len(res.Candidates) > 0 && res.Candidates[0].Status != "" {
+		linked, alternates := splitAssigned(res)
+		if linked != nil {
+			if err := o.matched.Write(linked); err != nil {
+				return err
+			}
+		}
+		if alternates != nil {
+			return o.ambiguous.Write(alternates)
+		}
+		return nil
+	}
+	switch len(res.Candidates) {
+	case 0:
+		return o.unmatchedOCLC.Write(res)
+	case 1:
+		return o.matched.Write(res)
+	}
+	return o.ambiguous.Write(res)
+}
+
+// WriteUnmatchedTind writes a TIND record no OCLC record matched
+func (o *outputs) WriteUnmatchedTind(rec *Record) error {
+	if o.unmatchedTind == nil {
+		return nil
+	}
+	return o.unmatchedTind.Write(&Result{Target: rec, Candidates: []*Candidate{}})
+}
+
+// Offsets flushes the output files and returns their sizes by name
+func (o *outputs) Offsets() (map[string]int64, error) {
+	offsets := map[string]int64{}
+	for i, buf := range o.buffers {
+		if err := buf.Flush(); err != nil {
+			return nil, err
+		}
+		offset, err := o.files[i].Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		offsets[o.names[i]] = offset
+	}
+	return offsets, nil
+}
+
+// Close flushes and closes the output files
+func (o *outputs) Close() error {
+	for _, buf := range o.buffers {
+		if err := buf.Flush(); err != nil {
+			return err
+		}
+	}
+	for _, fp := range o.files {
+		if err := fp.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
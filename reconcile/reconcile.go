@@ -2,11 +2,16 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/csv"
+	"errors"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"log/slog"
 	"os"
+	"path"
 	"strings"
 	"time"
 	// Caltech Library Packages
@@ -14,22 +19,25 @@ import (
 )
 
 type Record struct {
-	MaterialType string
-	MonoOrSerial string
-	Date1        string
-	Date2        string
-	Form         string
-	Tind         string
-	OCLC         string
-	ISBN         string
-	ISSN         string
-	Title        string
-	SubTitle     string
-	Author       string
-	Publisher    string
-	Year         string
-	Pagination   string
-	MatchedCount int
+	MaterialType string `json:"material_type"`
+	MonoOrSerial string `json:"mono_or_serial"`
+	Date1        string `json:"date1"`
+	Date2        string `json:"date2"`
+	Form         string `json:"form"`
+	Tind         string `json:"tind"`
+	OCLC         string `json:"oclc"`
+	ISBN         string `json:"isbn"`
+	ISSN         string `json:"issn"`
+	Title        string `json:"title"`
+	SubTitle     string `json:"subtitle"`
+	Author       string `json:"author"`
+	Publisher    string `json:"publisher"`
+	Year         string `json:"year"`
+	Pagination   string `json:"pagination"`
+	MatchedCount int    `json:"matched_count"`
+
+	// marc holds the source record when read from a MARC export
+	marc *MARCRecord
 }
 
 func (r *Record) Header() string {
@@ -47,42 +55,85 @@ func (r *Record) String() string {
 func RowToRecord(columnNames, row []string) *Record {
 	rec := new(Record)
 	for colNo, cName := range columnNames {
-		switch cName {
-		case "material type":
-			rec.MaterialType = row[colNo]
-		case "mono or serial":
-			rec.MonoOrSerial = row[colNo]
-		case "date1":
-			rec.Date1 = row[colNo]
-		case "date2":
-			rec.Date2 = row[colNo]
-		case "form":
-			rec.Form = row[colNo]
-		case "tind":
-			rec.Tind = row[colNo]
-		case "oclc":
-			rec.OCLC = row[colNo]
-		case "isbn":
-			rec.ISBN = row[colNo]
-		case "issn":
-			rec.ISSN = row[colNo]
-		case "title":
-			rec.Title = row[colNo]
-		case "subtitle":
-			rec.SubTitle = row[colNo]
-		case "author":
-			rec.Author = row[colNo]
-		case "publisher":
-			rec.Publisher = row[colNo]
-		case "year":
-			rec.Year = row[colNo]
-		case "pagination":
-			rec.Pagination = row[colNo]
-		}
+		rec.SetField(cName, row[colNo])
 	}
 	return rec
 }
 
+// SetField sets the value of the column named cName, unknown columns
+// are ignored
+func (r *Record) SetField(cName, val string) {
+	switch cName {
+	case "material type":
+		r.MaterialType = val
+	case "mono or serial":
+		r.MonoOrSerial = val
+	case "date1":
+		r.Date1 = val
+	case "date2":
+		r.Date2 = val
+	case "form":
+		r.Form = val
+	case "tind":
+		r.Tind = val
+	case "oclc":
+		r.OCLC = val
+	case "isbn":
+		r.ISBN = val
+	case "issn":
+		r.ISSN = val
+	case "title":
+		r.Title = val
+	case "subtitle":
+		r.SubTitle = val
+	case "author":
+		r.Author = val
+	case "publisher":
+		r.Publisher = val
+	case "year":
+		r.Year = val
+	case "pagination":
+		r.Pagination = val
+	}
+}
+
+// Field returns the value of the column named cName (see RowToRecord)
+func (r *Record) Field(cName string) string {
+	switch cName {
+	case "material type":
+		return r.MaterialType
+	case "mono or serial":
+		return r.MonoOrSerial
+	case "date1":
+		return r.Date1
+	case "date2":
+		return r.Date2
+	case "form":
+		return r.Form
+	case "tind":
+		return r.Tind
+	case "oclc":
+		return r.OCLC
+	case "isbn":
+		return r.ISBN
+	case "issn":
+		return r.ISSN
+	case "title":
+		return r.Title
+	case "subtitle":
+		return r.SubTitle
+	case "author":
+		return r.Author
+	case "publisher":
+		return r.Publisher
+	case "year":
+		return r.Year
+	case "pagination":
+		return r.Pagination
+	}
+	return ""
+}
+
 func mkTable(src []byte) ([][]string, error) {
 	r := csv.NewReader(bytes.NewReader(src))
 	table, err := r.ReadAll()
@@ -97,6 +148,9 @@ func mkRecords(src []byte, columnNames []string) ([]*Record, error) {
 	if err != nil {
 		return nil, err
 	}
+	if len(table) > 0 && len(table[0]) < len(columnNames) {
+		return nil, fmt.Errorf("found %d columns, expected %d (%s)", len(table[0]), len(columnNames), strings.Join(columnNames, ", "))
+	}
 	records := []*Record{}
 	for i, row := range table {
 		//NOTE: We need to skip the header row
@@ -108,6 +162,114 @@ func mkRecords(src []byte, columnNames []string) ([]*Record, error) {
 	return records, nil
 }
 
+// formatOf returns the input format named by format or, when format is
+// empty, guesses it from the file extension
+func formatOf(fName, format string) string {
+	if format != "" {
+		return format
+	}
+	switch strings.ToLower(path.Ext(fName)) {
+	case ".mrc", ".marc":
+		return "marc"
+	case ".xml", ".marcxml":
+		return "marcxml"
+	}
+	return "csv"
+}
+
+// loadRecords reads fName as CSV (using columnNames) or as MARC 21
+// binary or MARCXML (using mapping)
+func loadRecords(fName, format string, columnNames []string, mapping MARCMapping) ([]*Record, error) {
+	switch formatOf(fName, format) {
+	case "csv":
+		src, err := ioutil.ReadFile(fName)
+		if err != nil {
+			return nil, err
+		}
+		return mkRecords(src, columnNames)
+	case "marc":
+		fp, err := os.Open(fName)
+		if err != nil {
+			return nil, err
+		}
+		defer fp.Close()
+		return mkMARCRecords(NewMARCReader(fp), mapping)
+	case "marcxml":
+		fp, err := os.Open(fName)
+		if err != nil {
+			return nil, err
+		}
+		defer fp.Close()
+		return mkMARCRecords(NewMARCXMLReader(fp), mapping)
+	}
+	return nil, fmt.Errorf("unsupported format %q", format)
+}
+
+var (
+	// oclcColumns and tindColumns name the columns of the CSV exports
+	oclcColumns = []string{
+		"material type", // 0
+		"mono or serial",
+		"date1",
+		"date2",
+		"form", // 4
+		"isbn",
+		"issn",
+		"oclc", // 7
+		"title",
+		"subtitle",
+		"author",
+		"publisher",
+		"year",
+		"pagination",
+	}
+
+	tindColumns = []string{
+		"material type", // 0
+		"mono or serial",
+		"date1",
+		"date2",
+		"form", // 4
+		"tind",
+		"oclc", // 6
+		"isbn",
+		"issn",
+		"title",
+		"subtitle",
+		"author",
+		"publisher",
+		"year",
+		"pagination",
+	}
+)
+
+// loadExport reads the OCLC ("oclc") or TIND ("tind") export fName,
+// mappingFName optionally names a JSON file overriding the side's MARC
+// field mapping
+func loadExport(side, fName, format, mappingFName string) ([]*Record, error) {
+	columnNames, mapping := oclcColumns, oclcMARCMapping
+	if side == "tind" {
+		columnNames, mapping = tindColumns, tindMARCMapping
+	}
+	if mappingFName != "" {
+		m, err := LoadMARCMapping(mappingFName, mapping)
+		if err != nil {
+			return nil, fmt.Errorf("%s, %s", mappingFName, err)
+		}
+		mapping = m
+	}
+	return loadRecords(fName, format, columnNames, mapping)
+}
+
+// marcOutFormat picks the format of a MARC output file from its
+// extension, falling back on the format of the export it was read from
+func marcOutFormat(outFName, inFName, inFormat string) string {
+	if format := formatOf(outFName, ""); format != "csv" {
+		return format
+	}
+	return formatOf(inFName, inFormat)
+}
+
 func countTrue(booleans ...bool) int {
 	cnt := 0
 	for _, val := range booleans {
@@ -118,198 +280,508 @@ func countTrue(booleans ...bool) int {
 	return cnt
 }
 
-func Match(target, source *Record, withLevenshtein bool) bool {
+const (
+	// The passes which can match a target to a source
+	passExact       = "exact"
+	passTrimmed     = "trimmed"
+	passLevenshtein = "levenshtein"
+)
+
+// Score weighs the fields other than title which agree between target
+// and source, a match needs more than the config's threshold, by
+// default more than five of the nine.
+func Score(target, source *Record) int {
+	return matcher.Score(target, source)
+}
+
+// Compare reports field by field whether target and source agree
+func Compare(target, source *Record) map[string]bool {
+	return map[string]bool{
+		"material_type":  target.MaterialType == source.MaterialType,
+		"mono_or_serial": target.MonoOrSerial == source.MonoOrSerial,
+		"date1":          target.Date1 == source.Date1,
+		"date2":          target.Date2 == source.Date2,
+		"form":           target.Form == source.Form,
+		"isbn":           target.ISBN == source.ISBN,
+		"issn":           target.ISSN == source.ISSN,
+		"title":          target.Title == source.Title,
+		"subtitle":       target.SubTitle == source.SubTitle,
+		"author":         target.Author == source.Author,
+		"publisher":      target.Publisher == source.Publisher,
+		"year":           target.Year == source.Year,
+		"pagination":     target.Pagination == source.Pagination,
+	}
+}
+
+// MatchPass returns the name of the pass which matched target and
+// source or an empty string if they don't match
+func MatchPass(target, source *Record, withLevenshtein bool) string {
+	return matcher.MatchPass(target, source, withLevenshtein)
+}
+
+// MatchPass is MatchPass using mc's parameters
+func (mc *MatchConfig) MatchPass(target, source *Record, withLevenshtein bool) string {
+	if mc.Score(target, source) <= mc.Threshold {
+		return ""
+	}
+	return mc.titlePass(target.Title, source.Title, withLevenshtein, func() int {
+		return datatools.Levenshtein(target.Title, source.Title, 1, 1, 1, false)
+	})
+}
+
+// titlePass compares the titles for a pair whose other fields agree
+// well enough. The Levenshtein distance is only worked out when the
+// Levenshtein pass needs it.
+func (mc *MatchConfig) titlePass(targetTitle, sourceTitle string, withLevenshtein bool, distance func() int) string {
 	if withLevenshtein == true {
 		// Finally try using the Levenshtein approximate match without case sensitivety
-		if datatools.Levenshtein(target.Title, source.Title, 1, 1, 1, false) <= 1 &&
-			countTrue((target.MaterialType == source.MaterialType), (target.MonoOrSerial == source.MonoOrSerial),
-				(target.Date1 == source.Date1), (target.Date2 == source.Date2), (target.Form == source.Form),
-				(target.ISBN == source.ISBN), (target.ISSN == source.ISSN), (target.Publisher == source.Publisher),
-				(target.Year == source.Year)) > 5 {
-			return true
+		d := distance()
+		if d <= mc.Levenshtein {
+			return passLevenshtein
+		}
+		if mc.Similarity > 0 && similarity(d, targetTitle, sourceTitle) >= mc.Similarity {
+			return passLevenshtein
 		}
 	} else {
 		// Try simple unaltered string match
-		if target.Title == source.Title &&
-			countTrue((target.MaterialType == source.MaterialType), (target.MonoOrSerial == source.MonoOrSerial),
-				(target.Date1 == source.Date1), (target.Date2 == source.Date2), (target.Form == source.Form),
-				(target.ISBN == source.ISBN), (target.ISSN == source.ISSN), (target.Publisher == source.Publisher),
-				(target.Year == source.Year)) > 5 {
-			return true
+		if targetTitle == sourceTitle {
+			return passExact
 		}
 
 		// FIXME: Try comparing with stop words removed
 
 		// Try simple match strings where we trim lead/trailing spaces
-		if strings.TrimSpace(target.Title) == strings.TrimSpace(source.Title) &&
-			countTrue((target.MaterialType == source.MaterialType), (target.MonoOrSerial == source.MonoOrSerial),
-				(target.Date1 == source.Date1), (target.Date2 == source.Date2), (target.Form == source.Form),
-				(target.ISBN == source.ISBN), (target.ISSN == source.ISSN), (target.Publisher == source.Publisher),
-				(target.Year == source.Year)) > 5 {
-			return true
+		if strings.TrimSpace(targetTitle) == strings.TrimSpace(sourceTitle) {
+			return passTrimmed
 		}
 	}
-	return false
+	return ""
+}
+
+func Match(target, source *Record, withLevenshtein bool) bool {
+	return MatchPass(target, source, withLevenshtein) != ""
 }
 
+// Merge returns a copy of source with the ids it lacks taken from
+// target. Sources are copied so matching one source against several
+// targets doesn't leak ids or counts between them.
 func Merge(target, source *Record) *Record {
-	if source.Tind == "" {
-		source.Tind = target.Tind
+	merged := new(Record)
+	*merged = *source
+	if merged.Tind == "" {
+		merged.Tind = target.Tind
 	}
-	if source.OCLC == "" {
-		source.OCLC = target.OCLC
+	if merged.OCLC == "" {
+		merged.OCLC = target.OCLC
 	}
-	return source
+	return merged
 }
 
-func Scan(target *Record, sources []*Record, withLevenshtein bool) string {
-	matched := []*Record{}
-	for _, source := range sources {
-		if Match(target, source, withLevenshtein) == true {
-			matched = append(matched, Merge(target, source))
+// Candidate is a source which matched a target
+type Candidate struct {
+	Record  *Record         `json:"record"`
+	Pass    string          `json:"pass"`
+	Score   int             `json:"score"`
+	Fields  map[string]bool `json:"fields"`
+	Status  string          `json:"status,omitempty"`
+	Verdict string          `json:"verdict,omitempty"`
+
+	// source is the record Record was merged from
+	source *Record
+}
+
+// Scan returns the sources which match target, each merged with
+// target and stamped with the number of matches found. It gives up with
+// ctx's error when ctx is cancelled.
+func Scan(ctx context.Context, target *Record, sources []*Record, withLevenshtein bool) ([]*Candidate, error) {
+	return matcher.Scan(ctx, target, sources, withLevenshtein)
+}
+
+// Scan is Scan using mc's parameters
+func (mc *MatchConfig) Scan(ctx context.Context, target *Record, sources []*Record, withLevenshtein bool) ([]*Candidate, error) {
+	matched := []*Candidate{}
+	for i, source := range sources {
+		//NOTE: Checking every source would slow the scan down
+		if (i % 256) == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		if pass := mc.MatchPass(target, source, withLevenshtein); pass != "" {
+			matched = append(matched, &Candidate{
+				Record: Merge(target, source),
+				Pass:   pass,
+				Score:  mc.Score(target, source),
+				Fields: Compare(target, source),
+				source: source,
+			})
 		}
 	}
 	mCnt := len(matched)
 	if mCnt > 0 {
-		s := []string{}
-		for _, rec := range matched {
-			rec.MatchedCount = mCnt
-			s = append(s, rec.String())
+		for _, c := range matched {
+			c.Record.MatchedCount = mCnt
 		}
-		log.Printf("Found %d matches for %q", mCnt, target.Title)
-		return strings.Join(s, "\n")
+		slog.Debug("found matches", "matches", mCnt, "title", target.Title)
 	}
-	return ""
+	return matched, nil
 }
 
 func main() {
-	var (
-		oclcColumns = []string{
-			"material type", // 0
-			"mono or serial",
-			"date1",
-			"date2",
-			"form", // 4
-			"isbn",
-			"issn",
-			"oclc", // 7
-			"title",
-			"subtitle",
-			"author",
-			"publisher",
-			"year",
-			"pagination",
-		}
-
-		tindColumns = []string{
-			"material type", // 0
-			"mono or serial",
-			"date1",
-			"date2",
-			"form", // 4
-			"tind",
-			"oclc", // 6
-			"isbn",
-			"issn",
-			"title",
-			"subtitle",
-			"author",
-			"publisher",
-			"year",
-			"pagination",
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "serve":
+			serve(os.Args[2:])
+			return
+		case "refine":
+			refine(os.Args[2:])
+			return
+		case "review":
+			review(os.Args[2:])
+			return
+		case "evaluate":
+			evaluate(os.Args[2:])
+			return
+		case "tune":
+			tune(os.Args[2:])
+			return
+		case "generate":
+			generate(os.Args[2:])
+			return
+		case "bench":
+			bench(os.Args[2:])
+			return
+		case "dedupe":
+			dedupe(os.Args[2:])
+			return
 		}
+	}
+	var (
+		oclcFName   string
+		tindFName   string
+		oclcFormat  string
+		tindFormat  string
+		oclcMapping string
+		tindMapping string
+		tindMARCOut string
+		oclcMARCOut string
+		tindIDField string
+		outFormat   string
+
+		matchedOut       string
+		ambiguousOut     string
+		unmatchedOCLCOut string
+		unmatchedTindOut string
+		assign           bool
+
+		outFName        string
+		checkpointFName string
+		checkpointEvery int
+		resume          bool
+
+		manifestFName string
+		previousFName string
+		deltaFName    string
+
+		storeFName string
+		reload     bool
+
+		decisionsFName string
+
+		reportFName string
+		metricsAddr string
+		configFName string
+
+		componentsFName string
 	)
+	flag.StringVar(&oclcFName, "oclc", "data/rerun-oclc-all.csv", "OCLC export to reconcile")
+	flag.StringVar(&tindFName, "tind", "data/rerun-tind-all.csv", "TIND export to reconcile against")
+	flag.StringVar(&oclcFormat, "oclc-format", "", "OCLC export format, csv, marc or marcxml (default guessed from extension)")
+	flag.StringVar(&tindFormat, "tind-format", "", "TIND export format, csv, marc or marcxml (default guessed from extension)")
+	flag.StringVar(&oclcMapping, "oclc-mapping", "", "JSON file overriding the MARC field mapping for the OCLC export")
+	flag.StringVar(&tindMapping, "tind-mapping", "", "JSON file overriding the MARC field mapping for the TIND export")
+	flag.StringVar(&tindMARCOut, "tind-marc-out", "", "write the TIND MARC records gaining a matched OCLC number (035 (OCoLC)) to this file")
+	flag.StringVar(&oclcMARCOut, "oclc-marc-out", "", "write the OCLC MARC records gaining a matched TIND id to this file")
+	flag.StringVar(&tindIDField, "tind-id-field", "035$a=(TIND)", "where -oclc-marc-out puts the TIND id")
+	flag.StringVar(&outFormat, "format", "csv", "output format, csv (a row per match) or jsonl (a line per OCLC record with its candidates)")
+	flag.StringVar(&matchedOut, "matched", "", "write OCLC records with exactly one match to this file (default stdout)")
+	flag.StringVar(&ambiguousOut, "ambiguous", "", "write OCLC records with more than one match to this file (default stdout)")
+	flag.StringVar(&unmatchedOCLCOut, "unmatched-oclc", "", "write OCLC records without a match to this file (default stdout)")
+	flag.StringVar(&unmatchedTindOut, "unmatched-tind", "", "write TIND records no OCLC record matched to this file")
+	flag.BoolVar(&assign, "assign", false, "link each OCLC record to at most one TIND record and vice versa, keeping the losing candidates as alternates")
+	flag.StringVar(&outFName, "o", "", "write results to this file instead of stdout")
+	flag.StringVar(&checkpointFName, "checkpoint", "", "save the state of the run to this file so it can be resumed")
+	flag.IntVar(&checkpointEvery, "checkpoint-every", 1000, "OCLC rows between checkpoints")
+	flag.BoolVar(&resume, "resume", false, "resume the run saved in -checkpoint")
+	flag.StringVar(&manifestFName, "manifest", "", "write a manifest of the records read and links found, for the next run's -previous")
+	flag.StringVar(&previousFName, "previous", "", "reconcile incrementally against the manifest of a previous run")
+	flag.StringVar(&deltaFName, "delta", "", "with -previous, write the new, broken and changed links to this CSV file")
+	flag.StringVar(&storeFName, "store", "", "keep the records read and the pairs found in this embedded database, later runs read the records from it")
+	flag.BoolVar(&reload, "reload", false, "with -store, read the exports again and replace the records held in the store")
+	flag.StringVar(&decisionsFName, "decisions", "", "review decisions (oclc,tind,verdict CSV): rejected pairs are dropped, forced pairs always linked")
+	flag.StringVar(&reportFName, "report", "", "write a summary of the run to this file, JSON (.json), HTML (.html) or text")
+	flag.StringVar(&metricsAddr, "metrics-addr", "", "serve Prometheus metrics of the run at http://ADDR/metrics, e.g. localhost:9090")
+	flag.StringVar(&configFName, "config", "", "matcher config written by reconcile tune (default the built in matcher)")
+	flag.StringVar(&componentsFName, "components", "", "write the connected components of the pairs found to this file, CSV or JSON lines (.jsonl)")
+	logOpts := addLogFlags(flag.CommandLine)
+	flag.Parse()
+	if err := logOpts.setup(); err != nil {
+		log.Fatal(err)
+	}
 
-	percentage := func(x, y int) string {
-		if y != 0 {
-			f := (float64(x) / float64(y)) * 100.0
-			return fmt.Sprintf("%3.1f%%", f)
+	for _, fName := range []*string{&matchedOut, &ambiguousOut, &unmatchedOCLCOut} {
+		if *fName == "" {
+			*fName = outFName
+		}
+	}
+	if checkpointFName != "" {
+		if assign == true {
+			log.Fatal("-checkpoint can't be used with -assign, links are only assigned at the end of a run")
+		}
+		if matchedOut == "" || ambiguousOut == "" || unmatchedOCLCOut == "" {
+			log.Fatal("-checkpoint needs results written to files (-o), stdout can't be resumed")
+		}
+		if checkpointEvery < 1 {
+			log.Fatal("-checkpoint-every must be at least 1")
+		}
+	} else if resume == true {
+		log.Fatal("-resume needs -checkpoint")
+	}
+	if configFName != "" {
+		mc, err := LoadMatchConfig(configFName)
+		if err != nil {
+			log.Fatalf("Can't read %s, %s", configFName, err)
 		}
-		return "0%"
+		matcher = mc
+		slog.Info("matcher config", "config", configFName, "matcher", mc.String())
 	}
 
 	startT := time.Now()
-	oclcSrc, err := ioutil.ReadFile("data/rerun-oclc-all.csv")
-	if err != nil {
-		log.Fatal("Can't read data/rerun-oclc-all.csv, %s", err)
+	var m *metrics
+	if metricsAddr != "" {
+		m = newMetrics()
+		if err := serveMetrics(metricsAddr, m); err != nil {
+			log.Fatalf("Can't serve metrics at %s, %s", metricsAddr, err)
+		}
 	}
-	log.Printf("Read in data/rerun-oclc-all.csv, running time %s", time.Now().Sub(startT))
-	tindSrc, err := ioutil.ReadFile("data/rerun-tind-all.csv")
-	if err != nil {
-		log.Fatal("Can't read data/rerun-tind-all.csv, %s", err)
+	m.Phase(loadPhase)
+	var (
+		oclc, tind []*Record
+		store      *Store
+		err        error
+	)
+	if storeFName != "" {
+		store, err = OpenStore(storeFName)
+		if err != nil {
+			log.Fatalf("Can't open %s, %s", storeFName, err)
+		}
+		defer store.Close()
+		if reload == false {
+			if oclc, err = store.Records("oclc"); err != nil {
+				log.Fatalf("Can't read OCLC records from %s, %s", storeFName, err)
+			}
+			if tind, err = store.Records("tind"); err != nil {
+				log.Fatalf("Can't read TIND records from %s, %s", storeFName, err)
+			}
+			if len(oclc) > 0 && len(tind) > 0 {
+				flag.Visit(func(f *flag.Flag) {
+					if f.Name == "oclc" || f.Name == "tind" {
+						log.Fatalf("%s already holds records, -%s is only read with -reload", storeFName, f.Name)
+					}
+				})
+				slog.Info("read records from store", "store", storeFName, runningTime(startT))
+				if tindMARCOut != "" || oclcMARCOut != "" {
+					slog.Warn("records read from the store have no MARC, use -reload to write MARC output", "store", storeFName)
+				}
+			} else {
+				oclc, tind = nil, nil
+			}
+		}
 	}
-	log.Printf("Read in data/rerun-tind-all.csv, running time %s", time.Now().Sub(startT))
-
-	oclc, err := mkRecords(oclcSrc, oclcColumns)
-	if err != nil {
-		log.Fatal("Can't decode oclc CSV, %s", err)
+	if oclc == nil {
+		oclc, err = loadExport("oclc", oclcFName, oclcFormat, oclcMapping)
+		if err != nil {
+			log.Fatalf("Can't read %s, %s", oclcFName, err)
+		}
+		tind, err = loadExport("tind", tindFName, tindFormat, tindMapping)
+		if err != nil {
+			log.Fatalf("Can't read %s, %s", tindFName, err)
+		}
+		if store != nil {
+			if err := store.SaveRecords("oclc", oclc); err != nil {
+				log.Fatalf("Can't save OCLC records to %s, %s", storeFName, err)
+			}
+			if err := store.SaveRecords("tind", tind); err != nil {
+				log.Fatalf("Can't save TIND records to %s, %s", storeFName, err)
+			}
+			slog.Info("saved records to store", "store", storeFName, runningTime(startT))
+		}
 	}
-	oclcCnt := len(oclc)
-	log.Printf("oclc rows: %d, running time %s", oclcCnt, time.Now().Sub(startT))
-
-	tind, err := mkRecords(tindSrc, tindColumns)
+	slog.Info("read OCLC records", "rows", len(oclc), runningTime(startT))
+	slog.Info("read TIND records", "rows", len(tind), runningTime(startT))
+	loadedT := time.Now()
+	m.Loaded("oclc", len(oclc))
+	m.Loaded("tind", len(tind))
+	state := new(Checkpoint)
+	if resume == true {
+		state, err = LoadCheckpoint(checkpointFName)
+		if err != nil {
+			log.Fatalf("Can't read %s, %s", checkpointFName, err)
+		}
+		if state.Pass == phaseDone {
+			slog.Info("the run is already complete", "checkpoint", checkpointFName)
+			return
+		}
+		slog.Info("resuming", "phase", phaseNames[state.Pass], "row", state.Position, runningTime(startT))
+	}
+	out, err := openOutputs(outFormat, matchedOut, ambiguousOut, unmatchedOCLCOut, unmatchedTindOut, state.Offsets)
 	if err != nil {
-		log.Fatal("Can't decode tind CSV, %s", err)
+		log.Fatal(err)
 	}
-	log.Printf("tind rows: %d, running time %s", len(tind), time.Now().Sub(startT))
-	filterT := time.Now()
-	matchedCnt := 0
-	unmatchedCnt := 0
-	rec := new(Record)
-	// First pass will be of rows using Scan, the unmatched rows will then get scanned using separage Scan2
-	unmatched := []int{}
-	log.Printf("Running with simple title matching running time %s", time.Now().Sub(startT))
-	fmt.Fprintln(os.Stdout, rec.Header())
-	for i, rec := range oclc {
-		if s := Scan(rec, tind, false); s != "" {
-			fmt.Fprintf(os.Stdout, "%s\n", s)
-			matchedCnt++
-		} else {
-			unmatchedCnt++
-			unmatched = append(unmatched, i)
-		}
-		if (i % 100) == 0 {
-			t := time.Now()
-			log.Printf("%d matched, %d unmatched", matchedCnt, unmatchedCnt)
-			log.Printf("%d/%d (%s) rows processed in OCLC CSV, batch time %s, running time %s",
-				i, oclcCnt, percentage(i, oclcCnt), t.Sub(filterT), t.Sub(startT))
-			filterT = t
-		}
-	}
-	log.Printf("Running unmatched against Levenshtein title matching, running time %s", time.Now().Sub(startT))
-	filterT = time.Now()
-	unmatchedCnt = 0
-	missing := []int{}
-	phase2Cnt := len(unmatched)
-	for i, no := range unmatched {
-		rec := oclc[no]
-		if s := Scan(rec, tind, true); s != "" {
-			fmt.Fprintf(os.Stdout, "%s\n", s)
-			matchedCnt++
+	r := newRun(oclc, tind, out, state)
+	r.loaded(startT, loadedT)
+	r.assign = assign
+	r.store = store
+	r.metrics = m
+	if decisionsFName != "" || store != nil {
+		ds := NewDecisions()
+		if store != nil {
+			if ds, err = store.Decisions(); err != nil {
+				log.Fatalf("Can't read decisions from %s, %s", storeFName, err)
+			}
+		}
+		if decisionsFName != "" {
+			fileDs, err := LoadDecisions(decisionsFName)
+			if err != nil {
+				log.Fatalf("Can't read %s, %s", decisionsFName, err)
+			}
+			if err := ds.Merge(fileDs); err != nil {
+				log.Fatalf("Can't merge %s, %s", decisionsFName, err)
+			}
+			if store != nil {
+				if err := store.SaveDecisions(ds); err != nil {
+					log.Fatalf("Can't save decisions to %s, %s", storeFName, err)
+				}
+			}
+		}
+		slog.Info("review decisions", "decisions", len(ds.byPair), runningTime(startT))
+		r.SetDecisions(ds)
+	}
+	if store != nil && resume == false {
+		if err := store.ClearPairs(); err != nil {
+			log.Fatalf("Can't clear pairs in %s, %s", storeFName, err)
+		}
+	}
+	r.checkpointFName = checkpointFName
+	r.checkpointEvery = checkpointEvery
+	if resume == true {
+		if err := r.checkResume(); err != nil {
+			log.Fatalf("Can't resume, %s", err)
+		}
+	}
+	var previous *Manifest
+	if previousFName != "" {
+		previous, err = LoadManifest(previousFName)
+		if err != nil {
+			log.Fatalf("Can't read %s, %s", previousFName, err)
+		}
+		rematchCnt := r.planIncremental(previous)
+		slog.Info("OCLC rows need matching, the rest are carried over", "rematch", rematchCnt, "total", len(oclc),
+			"previous", previousFName, runningTime(startT))
+	}
+	ctx, stop := interruptible()
+	err = r.Run(ctx)
+	stop()
+	if errors.Is(err, context.Canceled) {
+		cpFName, err := r.saveInterrupted()
+		if err != nil {
+			log.Fatalf("Can't save the interrupted run, %s", err)
+		}
+		if err := out.Close(); err != nil {
+			log.Fatal(err)
+		}
+		rpt := r.Report()
+		if reportFName != "" {
+			if err := rpt.Save(reportFName); err != nil {
+				log.Fatalf("Can't write %s, %s", reportFName, err)
+			}
+		}
+		slog.Warn("run interrupted", "phase", rpt.Phase, "position", state.Position, "matched", rpt.Matched,
+			"unmatched", rpt.Unmatched, "pending", rpt.Pending, runningTime(startT))
+		if cpFName != "" {
+			slog.Warn("resume with the same options and -checkpoint "+cpFName+" -resume", "checkpoint", cpFName)
 		} else {
-			unmatchedCnt++
-			missing = append(missing, no)
-		}
-		if (i % 100) == 0 {
-			t := time.Now()
-			log.Printf("%d matched, %d unmatched", matchedCnt, unmatchedCnt)
-			log.Printf("%d/%d (%s) rows processed in OCLC CSV, batch time %s, running time %s",
-				i, phase2Cnt, percentage(i, phase2Cnt), t.Sub(filterT), t.Sub(startT))
-			filterT = t
-		}
-	}
-	log.Printf("Generating unmatched list (match count 0), running time %s", time.Now().Sub(startT))
-	filterT = time.Now()
-	phase3Cnt := len(missing)
-	for i, no := range missing {
-		oclc[no].MatchedCount = 0
-		fmt.Fprintf(os.Stdout, "%s\n", oclc[no].String())
-		if (i % 100) == 0 {
-			t := time.Now()
-			log.Printf("%d/%d (%s) rows processed in OCLC CSV, batch time %s, running time %s",
-				i, phase3Cnt, percentage(i, phase3Cnt), t.Sub(filterT), t.Sub(startT))
-			filterT = t
-		}
-	}
-	log.Printf("Running time %s", time.Now().Sub(startT))
+			slog.Warn("the run can't be resumed, it writes to stdout or assigns links")
+		}
+		if store != nil {
+			store.Close()
+		}
+		os.Exit(130)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := out.Close(); err != nil {
+		log.Fatal(err)
+	}
+	found := r.found
+	if reportFName != "" {
+		if err := r.Report().Save(reportFName); err != nil {
+			log.Fatalf("Can't write %s, %s", reportFName, err)
+		}
+		slog.Info("wrote report", "file", reportFName, runningTime(startT))
+	}
+	if manifestFName != "" {
+		if err := r.Manifest().Save(manifestFName); err != nil {
+			log.Fatalf("Can't write %s, %s", manifestFName, err)
+		}
+	}
+	if componentsFName != "" {
+		components := found.Components()
+		if err := WriteComponents(componentsFName, components); err != nil {
+			log.Fatalf("Can't write %s, %s", componentsFName, err)
+		}
+		kinds := map[string]int{}
+		for _, c := range components {
+			kinds[c.Kind]++
+		}
+		slog.Info("wrote components", "components", len(components), "file", componentsFName,
+			componentOneToMany, kinds[componentOneToMany], componentManyToOne, kinds[componentManyToOne],
+			componentManyToMany, kinds[componentManyToMany])
+	}
+	if previous != nil && deltaFName != "" {
+		cnt, err := writeDelta(deltaFName, previous.Links, found.pairs)
+		if err != nil {
+			log.Fatalf("Can't write %s, %s", deltaFName, err)
+		}
+		slog.Info("wrote changed links", "links", cnt, "file", deltaFName)
+	}
+	if tindMARCOut != "" || oclcMARCOut != "" {
+		oclcByTind, tindByOCLC := found.OneToOne()
+		if tindMARCOut != "" {
+			cnt, conflicts, err := writeMARCWithIdentifiers(tindMARCOut, marcOutFormat(tindMARCOut, tindFName, tindFormat), tind, "035$a=(OCoLC)", func(rec *Record) (string, string) {
+				return oclcByTind[rec.Tind], rec.OCLC
+			})
+			if err != nil {
+				log.Fatalf("Can't write %s, %s", tindMARCOut, err)
+			}
+			if conflicts > 0 {
+				slog.Warn("TIND records already holding a different OCLC number were not written", "records", conflicts, "file", tindMARCOut)
+			}
+			slog.Info("wrote TIND records", "records", cnt, "file", tindMARCOut, runningTime(startT))
+		}
+		if oclcMARCOut != "" {
+			cnt, conflicts, err := writeMARCWithIdentifiers(oclcMARCOut, marcOutFormat(oclcMARCOut, oclcFName, oclcFormat), oclc, tindIDField, func(rec *Record) (string, string) {
+				return tindByOCLC[rec.OCLC], rec.Tind
+			})
+			if err != nil {
+				log.Fatalf("Can't write %s, %s", oclcMARCOut, err)
+			}
+			if conflicts > 0 {
+				slog.Warn("OCLC records already holding a different TIND id were not written", "records", conflicts, "file", oclcMARCOut)
+			}
+			slog.Info("wrote OCLC records", "records", cnt, "file", oclcMARCOut, runningTime(startT))
+		}
+	}
+	slog.Info("done", runningTime(startT))
 }
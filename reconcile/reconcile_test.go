@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// testRecord returns a record with every field set, the ids left empty
+func testRecord() *Record {
+	return &Record{
+		MaterialType: "a",
+		MonoOrSerial: "m",
+		Date1:        "1985",
+		Date2:        "",
+		Form:         "o",
+		ISBN:         "9780201500646",
+		ISSN:         "0012-3456",
+		Title:        "The nature of light",
+		SubTitle:     "an introduction",
+		Author:       "Feynman, Richard",
+		Publisher:    "Addison-Wesley",
+		Year:         "1985",
+		Pagination:   "158 p.",
+	}
+}
+
+func TestCountTrue(t *testing.T) {
+	for _, tc := range []struct {
+		vals []bool
+		want int
+	}{
+		{nil, 0},
+		{[]bool{false}, 0},
+		{[]bool{true}, 1},
+		{[]bool{true, false, true}, 2},
+		{[]bool{true, true, true, true, true, true, true, true, true}, 9},
+	} {
+		if got := countTrue(tc.vals...); got != tc.want {
+			t.Errorf("countTrue(%v) = %d, want %d", tc.vals, got, tc.want)
+		}
+	}
+}
+
+func TestRowToRecord(t *testing.T) {
+	for _, side := range []struct {
+		name        string
+		columnNames []string
+	}{
+		{"oclc", oclcColumns},
+		{"tind", tindColumns},
+	} {
+		row := []string{}
+		for _, cName := range side.columnNames {
+			row = append(row, "v "+cName)
+		}
+		rec := RowToRecord(side.columnNames, row)
+		for _, cName := range side.columnNames {
+			if got := rec.Field(cName); got != "v "+cName {
+				t.Errorf("%s column %q read as %q", side.name, cName, got)
+			}
+		}
+		if rec.MatchedCount != 0 {
+			t.Errorf("%s row read with matched count %d", side.name, rec.MatchedCount)
+		}
+	}
+	rec := RowToRecord([]string{"title", "no such column"}, []string{"A title", "ignored"})
+	if rec.Title != "A title" {
+		t.Errorf("title read as %q beside an unknown column", rec.Title)
+	}
+}
+
+func TestScoreAndCompare(t *testing.T) {
+	target := testRecord()
+	if got := Score(target, testRecord()); got != 9 {
+		t.Errorf("identical records score %d, want 9", got)
+	}
+	for _, tc := range []struct {
+		cName  string
+		field  string
+		scored bool
+	}{
+		{"material type", "material_type", true},
+		{"mono or serial", "mono_or_serial", true},
+		{"date1", "date1", true},
+		{"date2", "date2", true},
+		{"form", "form", true},
+		{"isbn", "isbn", true},
+		{"issn", "issn", true},
+		{"publisher", "publisher", true},
+		{"year", "year", true},
+		{"title", "title", false},
+		{"subtitle", "subtitle", false},
+		{"author", "author", false},
+		{"pagination", "pagination", false},
+	} {
+		source := testRecord()
+		source.SetField(tc.cName, "different "+tc.cName)
+		want := 9
+		if tc.scored == true {
+			want = 8
+		}
+		got := Score(target, source)
+		if got != want {
+			t.Errorf("%s differing scores %d, want %d", tc.cName, got, want)
+		}
+		if Score(source, target) != got {
+			t.Errorf("%s differing scores differently the other way round", tc.cName)
+		}
+		fields := Compare(target, source)
+		for field, agree := range fields {
+			if agree != (field != tc.field) {
+				t.Errorf("%s differing, Compare says %s agree is %t", tc.cName, field, agree)
+			}
+		}
+		if len(fields) != 13 {
+			t.Errorf("Compare reports %d fields, want 13", len(fields))
+		}
+	}
+}
+
+func TestMatchPass(t *testing.T) {
+	// differ returns a copy of testRecord with its first n scored fields changed
+	differ := func(n int) *Record {
+		rec := testRecord()
+		for _, cName := range []string{"material type", "mono or serial", "date1", "date2", "form", "isbn", "issn", "publisher", "year"}[:n] {
+			rec.SetField(cName, "different")
+		}
+		return rec
+	}
+	for _, tc := range []struct {
+		name            string
+		title           string
+		differing       int
+		withLevenshtein bool
+		want            string
+	}{
+		{"same title", "The nature of light", 0, false, passExact},
+		{"trailing space", "The nature of light ", 0, false, passTrimmed},
+		{"leading space", " The nature of light", 0, false, passTrimmed},
+		{"one typo, exact pass", "The nature of lihgt", 0, false, ""},
+		{"one letter dropped", "The nature of ligt", 0, true, passLevenshtein},
+		{"one letter changed", "The nature of lignt", 0, true, passLevenshtein},
+		{"case differs, exact pass", "the nature of light", 0, false, ""},
+		{"case differs", "the NATURE of light", 0, true, passLevenshtein},
+		{"two letters swapped", "The nature of lihgt", 0, true, ""},
+		{"other title", "The nature of heat", 0, true, ""},
+		{"three fields differ", "The nature of light", 3, false, passExact},
+		{"four fields differ", "The nature of light", 4, false, ""},
+		{"four fields differ, Levenshtein", "The nature of ligt", 4, true, ""},
+	} {
+		source := differ(tc.differing)
+		source.Title = tc.title
+		target := testRecord()
+		got := MatchPass(target, source, tc.withLevenshtein)
+		if got != tc.want {
+			t.Errorf("%s: MatchPass = %q, want %q", tc.name, got, tc.want)
+		}
+		if MatchPass(source, target, tc.withLevenshtein) != got {
+			t.Errorf("%s: MatchPass differs the other way round", tc.name)
+		}
+		if Match(target, source, tc.withLevenshtein) != (got != "") {
+			t.Errorf("%s: Match disagrees with MatchPass", tc.name)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	target, source := testRecord(), testRecord()
+	target.OCLC, source.Tind = "101", "2001"
+	source.Title = "The nature of light "
+	merged := Merge(target, source)
+	if merged.OCLC != "101" || merged.Tind != "2001" {
+		t.Errorf("merged ids are %q/%q, want 101/2001", merged.OCLC, merged.Tind)
+	}
+	if merged.Title != source.Title {
+		t.Errorf("merged title %q, want the source's", merged.Title)
+	}
+	if source.OCLC != "" {
+		t.Errorf("Merge gave the source the OCLC number %q", source.OCLC)
+	}
+	if merged == source {
+		t.Errorf("Merge returned the source itself")
+	}
+
+	source.OCLC = "999"
+	if merged = Merge(target, source); merged.OCLC != "999" {
+		t.Errorf("Merge replaced the source's own OCLC number with %q", merged.OCLC)
+	}
+}
+
+func TestScan(t *testing.T) {
+	target := testRecord()
+	target.OCLC = "101"
+	sources := []*Record{}
+	for i, title := range []string{"The nature of light", "The nature of light", "The nature of ligt", "Other"} {
+		source := testRecord()
+		source.Tind, source.Title = fmt.Sprintf("%d", 2001+i), title
+		sources = append(sources, source)
+	}
+	matched, err := Scan(context.Background(), target, sources, false)
+	if err != nil {
+		t.Fatalf("Scan failed, %s", err)
+	}
+	if len(matched) != 2 {
+		t.Errorf("exact Scan found %d, want 2", len(matched))
+	}
+	for _, m := range matched {
+		if m.Record.MatchedCount != 2 {
+			t.Errorf("candidate %s stamped %d, want 2", m.Record.Tind, m.Record.MatchedCount)
+		}
+		if m.Record.OCLC != "101" {
+			t.Errorf("candidate %s merged with OCLC %q", m.Record.Tind, m.Record.OCLC)
+		}
+		if m.Pass != passExact || m.Score != 9 {
+			t.Errorf("candidate %s pass %q score %d", m.Record.Tind, m.Pass, m.Score)
+		}
+		if m.source.MatchedCount != 0 || m.source.OCLC != "" {
+			t.Errorf("Scan changed source %s", m.source.Tind)
+		}
+	}
+	// A later target matching the same sources gets its own number
+	other := testRecord()
+	other.OCLC = "102"
+	again, _ := Scan(context.Background(), other, sources[:1], false)
+	if len(again) != 1 || again[0].Record.OCLC != "102" || again[0].Record.MatchedCount != 1 {
+		t.Errorf("second target's candidate has OCLC %q and count %d, want 102 and 1", again[0].Record.OCLC, again[0].Record.MatchedCount)
+	}
+	if matched, _ = Scan(context.Background(), target, sources, true); len(matched) != 3 {
+		t.Errorf("Levenshtein Scan found %d, want 3", len(matched))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = Scan(ctx, target, sources, false); err != context.Canceled {
+		t.Errorf("Scan with a cancelled context returned %v", err)
+	}
+}
+
+func TestMkRecords(t *testing.T) {
+	header := strings.Join(oclcColumns, ",") + "\n"
+	for _, tc := range []struct {
+		name    string
+		src     string
+		records int
+		ok      bool
+	}{
+		{"empty", "", 0, true},
+		{"header only", header, 0, true},
+		{"two rows", header + "a,m,1985,,o,,,101,A title,,,,1985,\na,m,1990,,o,,,102,Another,,,,1990,\n", 2, true},
+		{"extra column", strings.TrimSuffix(header, "\n") + ",notes\na,m,1985,,o,,,101,A title,,,,1985,,a note\n", 1, true},
+		{"too few columns", "title,year\nA title,1985\n", 0, false},
+		{"ragged rows", header + "a,m\n", 0, false},
+	} {
+		records, err := mkRecords([]byte(tc.src), oclcColumns)
+		if (err == nil) != tc.ok {
+			t.Errorf("%s: mkRecords error %v", tc.name, err)
+			continue
+		}
+		if len(records) != tc.records {
+			t.Errorf("%s: mkRecords read %d records, want %d", tc.name, len(records), tc.records)
+		}
+	}
+}
+
+func FuzzMkRecords(f *testing.F) {
+	for _, src := range []string{
+		"",
+		"a,b\n",
+		"a,b\nc,d\n",
+		"material type,mono or serial,date1,date2,form,isbn,issn,oclc,title,subtitle,author,publisher,year,pagination\na,m,1985,,o,,,101,A title,,,,1985,\n",
+		"\"unterminated\n",
+		"a,\"b\nc\",d\r\n",
+	} {
+		f.Add([]byte(src))
+	}
+	f.Fuzz(func(t *testing.T, src []byte) {
+		records, err := mkRecords(src, oclcColumns)
+		if err == nil && records == nil {
+			t.Errorf("mkRecords returned neither records nor an error for %q", src)
+		}
+	})
+}
@@ -0,0 +1,533 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html/template"
+	"log"
+	"log/slog"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	// Caltech Library Packages
+	"github.com/caltechlibrary/datatools"
+)
+
+const (
+	// refineType is the only type of entity the service reconciles against
+	refineType     = "record"
+	refineTypeName = "TIND record"
+
+	// refineShortlist caps how many indexed records are scored per query
+	refineShortlist = 50
+)
+
+// refineProperties are the columns a query may match on besides the title
+var refineProperties = []string{
+	"isbn",
+	"issn",
+	"oclc",
+	"year",
+	"author",
+	"publisher",
+	"subtitle",
+	"date1",
+	"date2",
+	"material type",
+	"mono or serial",
+	"form",
+	"pagination",
+}
+
+// refineIDFields are indexed by value so a query giving one finds the
+// records sharing it whatever their title
+var refineIDFields = []string{"isbn", "issn", "oclc"}
+
+var refineStopWords = map[string]bool{
+	"and":  true,
+	"for":  true,
+	"from": true,
+	"the":  true,
+	"with": true,
+}
+
+// refineService answers OpenRefine reconciliation queries against the
+// TIND records using an in-memory index of their titles and identifiers
+type refineService struct {
+	name    string
+	tind    []*Record
+	byTind  map[string]*Record
+	byToken map[string][]int
+	byID    map[string]map[string][]int
+	preview *template.Template
+}
+
+type refineQuery struct {
+	Query      string            `json:"query"`
+	Type       string            `json:"type,omitempty"`
+	Limit      int               `json:"limit,omitempty"`
+	Properties []*refineProperty `json:"properties,omitempty"`
+}
+
+type refineProperty struct {
+	PID string          `json:"pid"`
+	V   json.RawMessage `json:"v"`
+}
+
+type refineEntityType struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type refineFeature struct {
+	ID    string  `json:"id"`
+	Value float64 `json:"value"`
+}
+
+type refineCandidate struct {
+	ID       string              `json:"id"`
+	Name     string              `json:"name"`
+	Score    float64             `json:"score"`
+	Match    bool                `json:"match"`
+	Type     []*refineEntityType `json:"type"`
+	Features []*refineFeature    `json:"features"`
+	pass     string
+}
+
+type refineResult struct {
+	Result []*refineCandidate `json:"result"`
+}
+
+const refinePreviewHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>TIND {{.Tind}}</title>
+<style>
+body { font-family: sans-serif; font-size: small; margin: 0.5em; }
+table { border-collapse: collapse; }
+th, td { padding: 0.1em 0.4em; text-align: left; vertical-align: top; }
+</style>
+</head>
+<body>
+<table>
+{{- range .Rows}}
+<tr><th>{{.Name}}</th><td>{{.Target}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`
+
+// titleTokens splits a title into the lower cased words used by the index
+func titleTokens(title string) []string {
+	tokens := []string{}
+	for _, token := range strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+		return unicode.IsLetter(r) == false && unicode.IsDigit(r) == false
+	}) {
+		if len(token) > 2 && refineStopWords[token] == false {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+// newRefineService indexes tind for querying
+func newRefineService(name string, tind []*Record) *refineService {
+	rs := &refineService{
+		name:    name,
+		tind:    tind,
+		byTind:  map[string]*Record{},
+		byToken: map[string][]int{},
+		byID:    map[string]map[string][]int{},
+		preview: template.Must(template.New("preview").Parse(refinePreviewHTML)),
+	}
+	for _, field := range refineIDFields {
+		rs.byID[field] = map[string][]int{}
+	}
+	for i, rec := range tind {
+		if rec.Tind != "" {
+			rs.byTind[rec.Tind] = rec
+		}
+		seen := map[string]bool{}
+		for _, token := range titleTokens(rec.Title) {
+			if seen[token] == false {
+				rs.byToken[token] = append(rs.byToken[token], i)
+				seen[token] = true
+			}
+		}
+		for _, field := range refineIDFields {
+			if val := strings.TrimSpace(rec.Field(field)); val != "" {
+				rs.byID[field][val] = append(rs.byID[field][val], i)
+			}
+		}
+	}
+	return rs
+}
+
+// propertyValue reads the value OpenRefine sent for a property, a
+// string, number, list (the first is used) or entity ({"id": ...})
+func propertyValue(src json.RawMessage) string {
+	var val interface{}
+	if err := json.Unmarshal(src, &val); err != nil {
+		return ""
+	}
+	switch v := val.(type) {
+	case string:
+		return strings.TrimSpace(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case []interface{}:
+		if len(v) > 0 {
+			src, _ := json.Marshal(v[0])
+			return propertyValue(src)
+		}
+	case map[string]interface{}:
+		for _, key := range []string{"id", "name", "str"} {
+			if s, ok := v[key].(string); ok == true {
+				return strings.TrimSpace(s)
+			}
+		}
+	}
+	return ""
+}
+
+// shortlist returns the positions of the records sharing an identifier
+// or the most title words with the query, best first
+func (rs *refineService) shortlist(q *refineQuery, given map[string]string) []int {
+	shared := map[int]int{}
+	for _, field := range refineIDFields {
+		if val, ok := given[field]; ok == true {
+			for _, i := range rs.byID[field][val] {
+				// An identifier outweighs any number of title words
+				shared[i] += 1000
+			}
+		}
+	}
+	for _, token := range titleTokens(q.Query) {
+		for _, i := range rs.byToken[token] {
+			shared[i]++
+		}
+	}
+	nos := []int{}
+	for i := range shared {
+		nos = append(nos, i)
+	}
+	sort.Slice(nos, func(a, b int) bool {
+		if shared[nos[a]] != shared[nos[b]] {
+			return shared[nos[a]] > shared[nos[b]]
+		}
+		return nos[a] < nos[b]
+	})
+	if len(nos) > refineShortlist {
+		nos = nos[:refineShortlist]
+	}
+	return nos
+}
+
+// titleSimilarity is one less the Levenshtein distance between two
+// titles over the length of the longer, ignoring case
+func titleSimilarity(a, b string) float64 {
+	a, b = strings.TrimSpace(a), strings.TrimSpace(b)
+	return similarity(datatools.Levenshtein(a, b, 1, 1, 1, false), a, b)
+}
+
+// queryMatcher returns mc weighing only the fields a query gives. The
+// threshold is cut in proportion to the weight given, so a query giving
+// every field is matched as a run would match it and a query giving
+// none (a bare title) is never matched.
+func queryMatcher(mc *MatchConfig, given map[string]string) *MatchConfig {
+	qm := mc.copy()
+	total, givenTotal := 0, 0
+	for _, field := range scoreFields {
+		w := mc.weight(field)
+		total += w
+		if _, ok := given[strings.Replace(field, "_", " ", -1)]; ok == true {
+			givenTotal += w
+		} else {
+			qm.Weights[field] = 0
+		}
+	}
+	if total > 0 {
+		qm.Threshold = mc.Threshold * givenTotal / total
+	}
+	return qm
+}
+
+// Reconcile scores the TIND records against one query. The query is
+// turned into a target record holding only the fields it gives, and
+// only those are weighed (see queryMatcher). Candidates Match accepts
+// score above those it doesn't. A candidate is a "match" when it is
+// the only one Match accepts and it was accepted without the
+// Levenshtein pass.
+func (rs *refineService) Reconcile(q *refineQuery) *refineResult {
+	given := map[string]string{}
+	for _, p := range q.Properties {
+		if val := propertyValue(p.V); val != "" {
+			given[p.PID] = val
+		}
+	}
+	target := &Record{Title: q.Query}
+	for pid, val := range given {
+		target.SetField(pid, val)
+	}
+	qm := queryMatcher(matcher, given)
+	candidates := []*refineCandidate{}
+	matchedCnt := 0
+	for _, i := range rs.shortlist(q, given) {
+		source := rs.tind[i]
+		agreeCnt := 0
+		for pid, val := range given {
+			if strings.EqualFold(val, strings.TrimSpace(source.Field(pid))) {
+				agreeCnt++
+			}
+		}
+		pass := qm.MatchPass(target, source, false)
+		if pass == "" {
+			pass = qm.MatchPass(target, source, true)
+		}
+		similarity := titleSimilarity(q.Query, source.Title)
+		agreement := 1.0
+		if len(given) > 0 {
+			agreement = float64(agreeCnt) / float64(len(given))
+		}
+		score := 100 * (0.6*similarity + 0.4*agreement)
+		if pass != "" {
+			matchedCnt++
+		} else {
+			// Candidates Match rejects rank below any it accepts
+			score /= 2
+		}
+		name := source.Title
+		if source.SubTitle != "" {
+			name += " " + source.SubTitle
+		}
+		candidates = append(candidates, &refineCandidate{
+			ID:    source.Tind,
+			Name:  name,
+			Score: math.Round(score*10) / 10,
+			Type:  []*refineEntityType{{ID: refineType, Name: refineTypeName}},
+			Features: []*refineFeature{
+				{ID: "title_similarity", Value: math.Round(similarity*1000) / 1000},
+				{ID: "property_agreement", Value: math.Round(agreement*1000) / 1000},
+				{ID: "match_score", Value: float64(qm.Score(target, source))},
+			},
+			pass: pass,
+		})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Score > candidates[j].Score
+	})
+	for _, c := range candidates {
+		c.Match = matchedCnt == 1 && (c.pass == passExact || c.pass == passTrimmed)
+	}
+	limit := q.Limit
+	if limit < 1 {
+		limit = 5
+	}
+	if len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+	return &refineResult{Result: candidates}
+}
+
+// baseURL is the address the client reached the service at
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+func (rs *refineService) manifest(r *http.Request) map[string]interface{} {
+	base := baseURL(r)
+	return map[string]interface{}{
+		"versions":        []string{"0.1", "0.2"},
+		"name":            rs.name,
+		"identifierSpace": base + "/tind/",
+		"schemaSpace":     base + "/schema/",
+		"defaultTypes":    []*refineEntityType{{ID: refineType, Name: refineTypeName}},
+		"view": map[string]string{
+			"url": base + "/preview?id={{id}}",
+		},
+		"preview": map[string]interface{}{
+			"url":    base + "/preview?id={{id}}",
+			"width":  430,
+			"height": 300,
+		},
+		"propose_properties": map[string]string{
+			"service_url":  base,
+			"service_path": "/properties",
+		},
+	}
+}
+
+// jsonpCallback is the form of a callback name writeJSON accepts, a
+// JavaScript identifier or dotted path and nothing which could run
+var jsonpCallback = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$.]*$`)
+
+// writeJSON writes val as JSON, or as JSONP when the request names a callback
+func writeJSON(w http.ResponseWriter, r *http.Request, val interface{}) {
+	callback := r.FormValue("callback")
+	if callback != "" && jsonpCallback.MatchString(callback) == false {
+		http.Error(w, "callback must be a JavaScript identifier", http.StatusBadRequest)
+		return
+	}
+	src, err := json.Marshal(val)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if callback != "" {
+		w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+		fmt.Fprintf(w, "%s(%s)", callback, src)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(src)
+}
+
+// parseQuery reads a single query, either a JSON object or a bare title
+func parseQuery(src string) *refineQuery {
+	q := new(refineQuery)
+	if strings.HasPrefix(strings.TrimSpace(src), "{") {
+		if err := json.Unmarshal([]byte(src), q); err == nil {
+			return q
+		}
+	}
+	return &refineQuery{Query: src}
+}
+
+func (rs *refineService) reconcile(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		return
+	}
+	if src := r.FormValue("queries"); src != "" {
+		queries := map[string]*refineQuery{}
+		if err := json.Unmarshal([]byte(src), &queries); err != nil {
+			http.Error(w, fmt.Sprintf("can't read queries, %s", err), http.StatusBadRequest)
+			return
+		}
+		startT := time.Now()
+		results := map[string]*refineResult{}
+		for key, q := range queries {
+			results[key] = rs.Reconcile(q)
+		}
+		slog.Info("reconciled queries", "queries", len(queries), runningTime(startT))
+		writeJSON(w, r, results)
+		return
+	}
+	if src := r.FormValue("query"); src != "" {
+		writeJSON(w, r, rs.Reconcile(parseQuery(src)))
+		return
+	}
+	writeJSON(w, r, rs.manifest(r))
+}
+
+func (rs *refineService) proposeProperties(w http.ResponseWriter, r *http.Request) {
+	properties := []*refineEntityType{}
+	for _, pid := range refineProperties {
+		properties = append(properties, &refineEntityType{ID: pid, Name: pid})
+	}
+	if limit, err := strconv.Atoi(r.FormValue("limit")); err == nil && limit > 0 && limit < len(properties) {
+		properties = properties[:limit]
+	}
+	writeJSON(w, r, map[string]interface{}{
+		"type":       refineType,
+		"properties": properties,
+	})
+}
+
+func (rs *refineService) showPreview(w http.ResponseWriter, r *http.Request) {
+	rec, ok := rs.byTind[r.FormValue("id")]
+	if ok == false {
+		http.NotFound(w, r)
+		return
+	}
+	page := struct {
+		Tind string
+		Rows []*reviewRow
+	}{
+		Tind: rec.Tind,
+		Rows: reviewRows(&Result{Target: rec}),
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := rs.preview.Execute(w, page); err != nil {
+		slog.Error("can't render preview", "tind", rec.Tind, "error", err)
+	}
+}
+
+// refine runs an OpenRefine reconciliation service for the TIND
+// records, "reconcile refine"
+func refine(args []string) {
+	var (
+		addr        string
+		name        string
+		tindFName   string
+		tindFormat  string
+		tindMapping string
+		storeFName  string
+		configFName string
+	)
+	fs := flag.NewFlagSet("refine", flag.ExitOnError)
+	fs.StringVar(&addr, "addr", "localhost:8001", "address to listen on")
+	fs.StringVar(&name, "name", "Caltech Library TIND", "service name shown in OpenRefine")
+	fs.StringVar(&tindFName, "tind", "data/rerun-tind-all.csv", "TIND export to reconcile against")
+	fs.StringVar(&tindFormat, "tind-format", "", "TIND export format, csv, marc or marcxml (default guessed from extension)")
+	fs.StringVar(&tindMapping, "tind-mapping", "", "JSON file overriding the MARC field mapping for the TIND export")
+	fs.StringVar(&storeFName, "store", "", "read the TIND records from this store instead of the export")
+	fs.StringVar(&configFName, "config", "", "matcher config written by reconcile tune (default the built in matcher)")
+	logOpts := addLogFlags(fs)
+	fs.Parse(args)
+	if err := logOpts.setup(); err != nil {
+		log.Fatal(err)
+	}
+	if configFName != "" {
+		mc, err := LoadMatchConfig(configFName)
+		if err != nil {
+			log.Fatalf("Can't read %s, %s", configFName, err)
+		}
+		matcher = mc
+	}
+
+	startT := time.Now()
+	var (
+		tind []*Record
+		err  error
+	)
+	if storeFName != "" {
+		store, err := OpenStore(storeFName)
+		if err != nil {
+			log.Fatalf("Can't open %s, %s", storeFName, err)
+		}
+		tind, err = store.Records("tind")
+		store.Close()
+		if err != nil {
+			log.Fatalf("Can't read TIND records from %s, %s", storeFName, err)
+		}
+	} else if tind, err = loadExport("tind", tindFName, tindFormat, tindMapping); err != nil {
+		log.Fatalf("Can't read %s, %s", tindFName, err)
+	}
+	rs := newRefineService(name, tind)
+	slog.Info("indexed TIND records", "records", len(tind), "title_words", len(rs.byToken), runningTime(startT))
+
+	http.HandleFunc("/reconcile", rs.reconcile)
+	http.HandleFunc("/properties", rs.proposeProperties)
+	http.HandleFunc("/preview", rs.showPreview)
+	slog.Info("reconciliation service", "url", "http://"+addr+"/reconcile")
+	log.Fatal(http.ListenAndServe(addr, nil))
+}
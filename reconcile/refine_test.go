@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func FuzzTitleSimilarity(f *testing.F) {
+	for _, pair := range [][2]string{{"", ""}, {"The nature of light", "The nature of ligt"}, {"a", " A "}, {"abc", "xyz"}} {
+		f.Add(pair[0], pair[1])
+	}
+	f.Fuzz(func(t *testing.T, a, b string) {
+		sim := titleSimilarity(a, b)
+		if sim < 0 || sim > 1 {
+			t.Errorf("titleSimilarity(%q, %q) = %g", a, b, sim)
+		}
+		if sim != titleSimilarity(b, a) {
+			t.Errorf("titleSimilarity(%q, %q) isn't symmetric", a, b)
+		}
+		if strings.TrimSpace(a) != "" && titleSimilarity(a, a) != 1 {
+			t.Errorf("titleSimilarity(%q, itself) = %g", a, titleSimilarity(a, a))
+		}
+	})
+}
+
+func TestWriteJSONCallback(t *testing.T) {
+	for _, tc := range []struct {
+		callback string
+		status   int
+		body     string
+	}{
+		{"", http.StatusOK, `{"ok":true}`},
+		{"jsonp123", http.StatusOK, `jsonp123({"ok":true})`},
+		{"jQuery.cb_1$", http.StatusOK, `jQuery.cb_1$({"ok":true})`},
+		{"alert(1);cb", http.StatusBadRequest, ""},
+		{"<script>", http.StatusBadRequest, ""},
+		{"1cb", http.StatusBadRequest, ""},
+		{"cb\n", http.StatusBadRequest, ""},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/reconcile?callback="+url.QueryEscape(tc.callback), nil)
+		writeJSON(w, r, map[string]bool{"ok": true})
+		if w.Code != tc.status {
+			t.Errorf("callback %q answered %d, want %d", tc.callback, w.Code, tc.status)
+			continue
+		}
+		if tc.status == http.StatusOK && w.Body.String() != tc.body {
+			t.Errorf("callback %q wrote %s, want %s", tc.callback, w.Body.String(), tc.body)
+		}
+	}
+}
+
+func TestQueryMatcher(t *testing.T) {
+	all := map[string]string{}
+	for _, field := range scoreFields {
+		all[strings.Replace(field, "_", " ", -1)] = "x"
+	}
+	for _, tc := range []struct {
+		name      string
+		given     map[string]string
+		threshold int
+	}{
+		{"every field", all, 5},
+		{"three fields", map[string]string{"isbn": "x", "year": "x", "publisher": "x"}, 1},
+		{"one field", map[string]string{"isbn": "x"}, 0},
+		{"fields not scored", map[string]string{"author": "x", "oclc": "x"}, 0},
+		{"nothing", map[string]string{}, 0},
+	} {
+		qm := queryMatcher(DefaultMatchConfig(), tc.given)
+		if qm.Threshold != tc.threshold {
+			t.Errorf("%s: threshold %d, want %d", tc.name, qm.Threshold, tc.threshold)
+		}
+		for _, field := range scoreFields {
+			_, ok := tc.given[strings.Replace(field, "_", " ", -1)]
+			if w := qm.weight(field); (w == 1) != ok {
+				t.Errorf("%s: %s weighs %d", tc.name, field, w)
+			}
+		}
+	}
+}
+
+func TestRefineReconcile(t *testing.T) {
+	source, edition := testRecord(), testRecord()
+	source.Tind, edition.Tind = "2001", "2002"
+	edition.Year, edition.Date1, edition.ISBN, edition.Publisher = "1999", "1999", "9780201000000", "Dover"
+	rs := newRefineService("test", []*Record{source, edition})
+	property := func(pid, val string) *refineProperty {
+		return &refineProperty{PID: pid, V: json.RawMessage(strconv.Quote(val))}
+	}
+	for _, tc := range []struct {
+		name       string
+		properties []*refineProperty
+		match      string
+	}{
+		// A bare title agrees on no field, it can't be matched
+		{"title only", nil, ""},
+		{"isbn and year", []*refineProperty{property("isbn", "9780201500646"), property("year", "1985")}, "2001"},
+		{"other edition", []*refineProperty{property("isbn", "9780201000000"), property("year", "1999"), property("publisher", "Dover")}, "2002"},
+		{"wrong year and publisher", []*refineProperty{property("year", "2020"), property("publisher", "Penguin")}, ""},
+	} {
+		res := rs.Reconcile(&refineQuery{Query: "The nature of light", Properties: tc.properties})
+		if len(res.Result) != 2 {
+			t.Errorf("%s: %d candidates, want 2", tc.name, len(res.Result))
+			continue
+		}
+		match := ""
+		for _, c := range res.Result {
+			if c.Match == true {
+				match = c.ID
+			}
+		}
+		if match != tc.match {
+			t.Errorf("%s: matched %q, want %q", tc.name, match, tc.match)
+		}
+	}
+
+	// A title hit alone isn't a match even with no other candidate
+	res := newRefineService("test", []*Record{source}).Reconcile(&refineQuery{Query: "The nature of light"})
+	if len(res.Result) != 1 || res.Result[0].Match == true {
+		t.Errorf("a bare title was matched, %+v", res.Result)
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// phaseNames names the phases of a run in reports
+var phaseNames = map[int]string{
+	phaseExact:         "exact and trimmed titles",
+	phaseLevenshtein:   "Levenshtein titles",
+	phaseUnmatchedOCLC: "unmatched OCLC list",
+	phaseUnmatchedTind: "unmatched TIND list",
+}
+
+// loadPhase names reading the records, before the phases of a run
+const loadPhase = "reading records"
+
+// PhaseTiming is how long a phase of a run took
+type PhaseTiming struct {
+	Phase   string  `json:"phase"`
+	Seconds float64 `json:"seconds"`
+}
+
+// PassTally counts what a pass found, Records are the OCLC records whose
+// best candidate came from the pass
+type PassTally struct {
+	Pass    string `json:"pass"`
+	Pairs   int    `json:"pairs"`
+	Records int    `json:"records"`
+}
+
+// Tally is the match rate of the OCLC records sharing a field value
+type Tally struct {
+	Value   string  `json:"value"`
+	Records int     `json:"records"`
+	Matched int     `json:"matched"`
+	Rate    float64 `json:"rate"`
+}
+
+// AmbiguityTally counts the OCLC records with a given number of candidates
+type AmbiguityTally struct {
+	MatchedCount int `json:"matched_count"`
+	Records      int `json:"records"`
+}
+
+// Report summarizes a run
+type Report struct {
+	Finished       string            `json:"finished"`
+	Complete       bool              `json:"complete"`
+	Phase          string            `json:"phase,omitempty"`
+	OCLCCount      int               `json:"oclc_count"`
+	TindCount      int               `json:"tind_count"`
+	Matched        int               `json:"matched"`
+	Unmatched      int               `json:"unmatched"`
+	Pending        int               `json:"pending"`
+	MatchRate      float64           `json:"match_rate"`
+	Pairs          int               `json:"pairs"`
+	UnmatchedTind  int               `json:"unmatched_tind"`
+	ByPass         []*PassTally      `json:"by_pass"`
+	ByMaterialType []*Tally          `json:"by_material_type"`
+	ByMonoOrSerial []*Tally          `json:"by_mono_or_serial"`
+	ByForm         []*Tally          `json:"by_form"`
+	Ambiguity      []*AmbiguityTally `json:"ambiguity"`
+	Phases         []*PhaseTiming    `json:"phases"`
+	Seconds        float64           `json:"seconds"`
+}
+
+// rate is x as a fraction of y
+func rate(x, y int) float64 {
+	if y == 0 {
+		return 0
+	}
+	return float64(x) / float64(y)
+}
+
+// tallyBy breaks the OCLC records down by the value of a field, records
+// still pending are left out
+func tallyBy(oclc []*Record, unmatched, pending map[int]bool, cName string) []*Tally {
+	byValue := map[string]*Tally{}
+	for i, rec := range oclc {
+		if pending[i] {
+			continue
+		}
+		val := rec.Field(cName)
+		t, ok := byValue[val]
+		if ok == false {
+			t = &Tally{Value: val}
+			byValue[val] = t
+		}
+		t.Records++
+		if unmatched[i] == false {
+			t.Matched++
+		}
+	}
+	tallies := []*Tally{}
+	for _, t := range byValue {
+		t.Rate = rate(t.Matched, t.Records)
+		tallies = append(tallies, t)
+	}
+	sort.Slice(tallies, func(i, j int) bool {
+		if tallies[i].Records != tallies[j].Records {
+			return tallies[i].Records > tallies[j].Records
+		}
+		return tallies[i].Value < tallies[j].Value
+	})
+	return tallies
+}
+
+// Report summarizes the run. It is built from the run's state and
+// links so it covers resumed and incremental runs, the phase timings
+// only cover phases run by this process. A run stopped part way gives a
+// partial report, OCLC records the first pass hasn't reached are
+// pending and those only waiting on the Levenshtein pass are unmatched.
+func (r *run) Report() *Report {
+	st := r.state
+	unmatched := map[int]bool{}
+	pending := map[int]bool{}
+	for _, no := range st.Missing {
+		unmatched[no] = true
+	}
+	switch st.Pass {
+	case phaseExact:
+		for _, no := range st.Unmatched {
+			unmatched[no] = true
+		}
+		for no := st.Position; no < len(r.oclc); no++ {
+			pending[no] = true
+		}
+	case phaseLevenshtein:
+		for i := st.Position; i < len(st.Unmatched); i++ {
+			unmatched[st.Unmatched[i]] = true
+		}
+	}
+	rpt := &Report{
+		Finished:      time.Now().Format(time.RFC3339),
+		Complete:      st.Pass == phaseDone,
+		OCLCCount:     len(r.oclc),
+		TindCount:     len(r.tind),
+		Unmatched:     len(unmatched),
+		Pending:       len(pending),
+		UnmatchedTind: len(r.tind) - len(r.matchedTind),
+		Phases:        r.timings,
+		Seconds:       time.Now().Sub(r.startT).Seconds(),
+	}
+	if rpt.Complete == false {
+		rpt.Phase = phaseNames[st.Pass]
+		rpt.Phases = append(rpt.Phases, &PhaseTiming{Phase: rpt.Phase + " (stopped)", Seconds: time.Now().Sub(r.phaseT).Seconds()})
+	}
+	rpt.Matched = rpt.OCLCCount - rpt.Unmatched - rpt.Pending
+	rpt.MatchRate = rate(rpt.Matched, rpt.OCLCCount)
+
+	// Passes and candidate counts come from the records output with
+	// candidates, by position so records without an OCLC number count
+	byPass := map[string]*PassTally{}
+	candidates := map[int]int{}
+	for _, m := range st.Matches {
+		best := ""
+		for _, pass := range m.Passes {
+			t, ok := byPass[pass]
+			if ok == false {
+				t = &PassTally{Pass: pass}
+				byPass[pass] = t
+			}
+			t.Pairs++
+			rpt.Pairs++
+			if best == "" || passRank[pass] < passRank[best] {
+				best = pass
+			}
+		}
+		byPass[best].Records++
+		candidates[m.No] = len(m.Passes)
+	}
+	for _, t := range byPass {
+		rpt.ByPass = append(rpt.ByPass, t)
+	}
+	sort.Slice(rpt.ByPass, func(i, j int) bool {
+		return passRank[rpt.ByPass[i].Pass] < passRank[rpt.ByPass[j].Pass]
+	})
+
+	rpt.ByMaterialType = tallyBy(r.oclc, unmatched, pending, "material type")
+	rpt.ByMonoOrSerial = tallyBy(r.oclc, unmatched, pending, "mono or serial")
+	rpt.ByForm = tallyBy(r.oclc, unmatched, pending, "form")
+
+	byCount := map[int]int{0: rpt.Unmatched}
+	for _, cnt := range candidates {
+		byCount[cnt]++
+	}
+	for cnt, records := range byCount {
+		rpt.Ambiguity = append(rpt.Ambiguity, &AmbiguityTally{MatchedCount: cnt, Records: records})
+	}
+	sort.Slice(rpt.Ambiguity, func(i, j int) bool {
+		return rpt.Ambiguity[i].MatchedCount < rpt.Ambiguity[j].MatchedCount
+	})
+	return rpt
+}
+
+// displayValue shows empty field values in text and HTML reports
+func displayValue(val string) string {
+	if val == "" {
+		return "(blank)"
+	}
+	return val
+}
+
+// WriteText writes the report as aligned plain text
+func (rpt *Report) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Reconciliation report, %s\n\n", rpt.Finished)
+	if rpt.Complete == false {
+		fmt.Fprintf(tw, "The run stopped during the %s phase, this report is partial\n\n", rpt.Phase)
+	}
+	fmt.Fprintf(tw, "OCLC records\t%d\n", rpt.OCLCCount)
+	fmt.Fprintf(tw, "TIND records\t%d\n", rpt.TindCount)
+	fmt.Fprintf(tw, "Matched OCLC records\t%d\t%s\n", rpt.Matched, percentage(rpt.Matched, rpt.OCLCCount))
+	fmt.Fprintf(tw, "Unmatched OCLC records\t%d\t%s\n", rpt.Unmatched, percentage(rpt.Unmatched, rpt.OCLCCount))
+	if rpt.Pending > 0 {
+		fmt.Fprintf(tw, "OCLC records not yet matched\t%d\t%s\n", rpt.Pending, percentage(rpt.Pending, rpt.OCLCCount))
+	}
+	fmt.Fprintf(tw, "Unmatched TIND records\t%d\t%s\n", rpt.UnmatchedTind, percentage(rpt.UnmatchedTind, rpt.TindCount))
+	fmt.Fprintf(tw, "Candidate pairs\t%d\n", rpt.Pairs)
+
+	fmt.Fprintf(tw, "\nBy pass\tpairs\tbest for\n")
+	for _, t := range rpt.ByPass {
+		fmt.Fprintf(tw, "%s\t%d\t%d\n", t.Pass, t.Pairs, t.Records)
+	}
+	for _, section := range []struct {
+		name    string
+		tallies []*Tally
+	}{
+		{"material type", rpt.ByMaterialType},
+		{"mono or serial", rpt.ByMonoOrSerial},
+		{"form", rpt.ByForm},
+	} {
+		fmt.Fprintf(tw, "\nBy %s\trecords\tmatched\trate\n", section.name)
+		for _, t := range section.tallies {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%s\n", displayValue(t.Value), t.Records, t.Matched, percentage(t.Matched, t.Records))
+		}
+	}
+	fmt.Fprintf(tw, "\nBy matched count\trecords\n")
+	for _, t := range rpt.Ambiguity {
+		fmt.Fprintf(tw, "%d\t%d\n", t.MatchedCount, t.Records)
+	}
+	fmt.Fprintf(tw, "\nBy phase\ttime\n")
+	for _, t := range rpt.Phases {
+		fmt.Fprintf(tw, "%s\t%s\n", t.Phase, time.Duration(t.Seconds*float64(time.Second)).Round(time.Millisecond))
+	}
+	fmt.Fprintf(tw, "total\t%s\n", time.Duration(rpt.Seconds*float64(time.Second)).Round(time.Millisecond))
+	return tw.Flush()
+}
+
+// WriteJSON writes the report as indented JSON
+func (rpt *Report) WriteJSON(w io.Writer) error {
+	src, err := json.MarshalIndent(rpt, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", src)
+	return err
+}
+
+const reportHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Reconciliation report, {{.Finished}}</title>
+<style>
+body { font-family: sans-serif; margin: 1em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 0.2em 0.6em; text-align: left; }
+td.n { text-align: right; }
+.bar { background: #8ab; height: 0.8em; display: inline-block; }
+</style>
+</head>
+<body>
+<h1>Reconciliation report</h1>
+<p>{{.Finished}}</p>
+{{- if not .Complete}}
+<p><strong>The run stopped during the {{.Phase}} phase, this report is partial.</strong></p>
+{{- end}}
+<table>
+<tr><th>OCLC records</th><td class="n">{{.OCLCCount}}</td><td></td></tr>
+<tr><th>TIND records</th><td class="n">{{.TindCount}}</td><td></td></tr>
+<tr><th>Matched OCLC records</th><td class="n">{{.Matched}}</td><td class="n">{{percent .MatchRate}}</td></tr>
+<tr><th>Unmatched OCLC records</th><td class="n">{{.Unmatched}}</td><td></td></tr>
+{{- if .Pending}}
+<tr><th>OCLC records not yet matched</th><td class="n">{{.Pending}}</td><td></td></tr>
+{{- end}}
+<tr><th>Unmatched TIND records</th><td class="n">{{.UnmatchedTind}}</td><td></td></tr>
+<tr><th>Candidate pairs</th><td class="n">{{.Pairs}}</td><td></td></tr>
+</table>
+<h2>By pass</h2>
+<table>
+<tr><th>pass</th><th>pairs</th><th>best for</th></tr>
+{{- range .ByPass}}
+<tr><td>{{.Pass}}</td><td class="n">{{.Pairs}}</td><td class="n">{{.Records}}</td></tr>
+{{- end}}
+</table>
+{{- range sections .}}
+<h2>By {{.Name}}</h2>
+<table>
+<tr><th>{{.Name}}</th><th>records</th><th>matched</th><th>rate</th><th></th></tr>
+{{- range .Tallies}}
+<tr><td>{{blank .Value}}</td><td class="n">{{.Records}}</td><td class="n">{{.Matched}}</td><td class="n">{{percent .Rate}}</td><td><span class="bar" style="width: {{width .Rate}}px"></span></td></tr>
+{{- end}}
+</table>
+{{- end}}
+<h2>By matched count</h2>
+<table>
+<tr><th>matched count</th><th>records</th></tr>
+{{- range .Ambiguity}}
+<tr><td class="n">{{.MatchedCount}}</td><td class="n">{{.Records}}</td></tr>
+{{- end}}
+</table>
+<h2>By phase</h2>
+<table>
+<tr><th>phase</th><th>seconds</th></tr>
+{{- range .Phases}}
+<tr><td>{{.Phase}}</td><td class="n">{{printf "%.3f" .Seconds}}</td></tr>
+{{- end}}
+<tr><th>total</th><td class="n">{{printf "%.3f" .Seconds}}</td></tr>
+</table>
+</body>
+</html>
+`
+
+// WriteHTML writes the report as a self contained HTML page
+func (rpt *Report) WriteHTML(w io.Writer) error {
+	type section struct {
+		Name    string
+		Tallies []*Tally
+	}
+	page := template.Must(template.New("report").Funcs(template.FuncMap{
+		"percent": func(f float64) string { return fmt.Sprintf("%3.1f%%", f*100) },
+		"blank":   displayValue,
+		"width":   func(f float64) int { return int(f * 200) },
+		"sections": func(rpt *Report) []section {
+			return []section{
+				{"material type", rpt.ByMaterialType},
+				{"mono or serial", rpt.ByMonoOrSerial},
+				{"form", rpt.ByForm},
+			}
+		},
+	}).Parse(reportHTML))
+	return page.Execute(w, rpt)
+}
+
+// Save writes the report to fName as JSON (.json), HTML (.html) or text
+func (rpt *Report) Save(fName string) error {
+	fp, err := os.Create(fName)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	switch strings.ToLower(path.Ext(fName)) {
+	case ".json":
+		err = rpt.WriteJSON(fp)
+	case ".html", ".htm":
+		err = rpt.WriteHTML(fp)
+	default:
+		err = rpt.WriteText(fp)
+	}
+	if err != nil {
+		return err
+	}
+	return fp.Close()
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReportTotals(t *testing.T) {
+	oclc, err := loadExport("oclc", "testdata/oclc.csv", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tind, err := loadExport("tind", "testdata/tind.csv", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Records without an OCLC number, or sharing one, still count
+	oclc[0].OCLC = ""
+	oclc[2].OCLC = oclc[1].OCLC
+	outFName := filepath.Join(t.TempDir(), "out.csv")
+	out, err := openOutputs("csv", outFName, outFName, outFName, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	r := newRun(oclc, tind, out, new(Checkpoint))
+	loadedT := time.Now()
+	r.loaded(loadedT.Add(-2*time.Second), loadedT)
+	if err := r.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	rpt := r.Report()
+	if len(rpt.Phases) == 0 || rpt.Phases[0].Phase != loadPhase || rpt.Phases[0].Seconds != 2 {
+		t.Errorf("phases %v, want %q first taking 2 seconds", rpt.Phases, loadPhase)
+	}
+	if rpt.Seconds < 2 {
+		t.Errorf("run took %.2f seconds, want the 2 seconds reading records counted", rpt.Seconds)
+	}
+	if rpt.Matched+rpt.Unmatched != len(oclc) {
+		t.Errorf("%d matched and %d unmatched of %d records", rpt.Matched, rpt.Unmatched, len(oclc))
+	}
+	records, pairs := 0, 0
+	for _, p := range rpt.ByPass {
+		records += p.Records
+		pairs += p.Pairs
+	}
+	if records != rpt.Matched {
+		t.Errorf("passes tally %d records, %d matched", records, rpt.Matched)
+	}
+	if pairs != rpt.Pairs {
+		t.Errorf("passes tally %d pairs, the report has %d", pairs, rpt.Pairs)
+	}
+	records = 0
+	for _, a := range rpt.Ambiguity {
+		records += a.Records
+	}
+	if records != len(oclc) {
+		t.Errorf("ambiguity tallies %d records, want %d", records, len(oclc))
+	}
+}
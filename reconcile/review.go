@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// reviewFields are the columns shown side by side when reviewing, in order
+var reviewFields = []string{
+	"title",
+	"subtitle",
+	"author",
+	"publisher",
+	"year",
+	"date1",
+	"date2",
+	"isbn",
+	"issn",
+	"material type",
+	"mono or serial",
+	"form",
+	"pagination",
+	"oclc",
+	"tind",
+}
+
+// loadResults reads the results of a run from a JSON Lines output
+// file (see -format jsonl) or, when fName is empty, from store
+func loadResults(fName string, store *Store) ([]*Result, error) {
+	if fName == "" {
+		if store == nil {
+			return nil, fmt.Errorf("no results to review, give a JSON Lines output or a store")
+		}
+		return store.Results()
+	}
+	fp, err := os.Open(fName)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	results := []*Result{}
+	scanner := bufio.NewScanner(fp)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for i := 1; scanner.Scan(); i++ {
+		res := new(Result)
+		if err := json.Unmarshal(scanner.Bytes(), res); err != nil {
+			return nil, fmt.Errorf("%s line %d, %s", fName, i, err)
+		}
+		results = append(results, res)
+	}
+	return results, scanner.Err()
+}
+
+// loadReview reads the results and decisions to review. With a store the
+// results come from it when resultsFName is empty and its decisions are
+// merged with the decisions file, the newest verdict on a pair wins. The
+// store is only held open while reading so runs can use it during a
+// review.
+func loadReview(resultsFName, storeFName, decisionsFName string) ([]*Result, *Decisions, error) {
+	decisions, err := LoadDecisions(decisionsFName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't read %s, %s", decisionsFName, err)
+	}
+	if storeFName == "" {
+		results, err := loadResults(resultsFName, nil)
+		return results, decisions, err
+	}
+	store, err := OpenStoreReadOnly(storeFName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't open %s, %s", storeFName, err)
+	}
+	defer store.Close()
+	results, err := loadResults(resultsFName, store)
+	if err != nil {
+		return nil, nil, err
+	}
+	ds, err := store.Decisions()
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't read decisions from %s, %s", storeFName, err)
+	}
+	if err := ds.Merge(decisions); err != nil {
+		return nil, nil, fmt.Errorf("can't merge %s, %s", decisionsFName, err)
+	}
+	return results, ds, nil
+}
+
+// uncertain reports if a result needs a reviewer, it has more than one
+// candidate or a candidate only found by approximate title matching
+func uncertain(res *Result) bool {
+	if len(res.Candidates) > 1 {
+		return true
+	}
+	for _, c := range res.Candidates {
+		if c.Pass == passLevenshtein || c.Status == statusAlternate {
+			return true
+		}
+	}
+	return false
+}
+
+// reviewQueue picks the results to review, only is "all" (any result
+// with candidates), "ambiguous" (more than one candidate) or "uncertain"
+func reviewQueue(results []*Result, only string) ([]*Result, error) {
+	queue := []*Result{}
+	for _, res := range results {
+		if len(res.Candidates) == 0 {
+			continue
+		}
+		switch only {
+		case "all":
+			queue = append(queue, res)
+		case "ambiguous":
+			if len(res.Candidates) > 1 {
+				queue = append(queue, res)
+			}
+		case "uncertain":
+			if uncertain(res) {
+				queue = append(queue, res)
+			}
+		default:
+			return nil, fmt.Errorf("can't review %q, use all, ambiguous or uncertain", only)
+		}
+	}
+	return queue, nil
+}
+
+// reviewRow is one field of a target beside the same field of its candidates
+type reviewRow struct {
+	Name   string
+	Target string
+	Values []reviewValue
+}
+
+type reviewValue struct {
+	Value   string
+	Differs bool
+}
+
+// reviewRows lines up the fields of res for display
+func reviewRows(res *Result) []*reviewRow {
+	rows := []*reviewRow{}
+	for _, name := range reviewFields {
+		row := &reviewRow{Name: name, Target: res.Target.Field(name)}
+		for _, c := range res.Candidates {
+			val := c.Record.Field(name)
+			// The ids of a target are only missing on its own side
+			differs := val != row.Target && !((name == "tind" || name == "oclc") && row.Target == "")
+			row.Values = append(row.Values, reviewValue{Value: val, Differs: differs})
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
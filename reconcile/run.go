@@ -0,0 +1,441 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const (
+	// The phases of a run, in order
+	phaseExact         = 1 // exact and trimmed titles, all OCLC records
+	phaseLevenshtein   = 2 // Levenshtein titles, OCLC records phaseExact left unmatched
+	phaseUnmatchedOCLC = 3 // list the OCLC records still unmatched
+	phaseUnmatchedTind = 4 // list the TIND records never matched
+	phaseDone          = 5
+)
+
+// Link is an OCLC/TIND id pair found by a run
+type Link struct {
+	OCLC   string `json:"oclc"`
+	Tind   string `json:"tind"`
+	Pass   string `json:"pass"`
+	Status string `json:"status,omitempty"`
+}
+
+// links holds the OCLC/TIND id pairs found by a run
+type links struct {
+	pairs []*Link
+}
+
+// Add records that the OCLC target matched the TIND source
+func (l *links) Add(oclcID string, c *Candidate) {
+	if oclcID != "" && c.Record.Tind != "" {
+		l.pairs = append(l.pairs, &Link{OCLC: oclcID, Tind: c.Record.Tind, Pass: c.Pass, Status: c.Status})
+	}
+}
+
+// OneToOne returns the OCLC numbers keyed by TIND id and the TIND ids
+// keyed by OCLC number for the pairs where neither id was linked to
+// anything else. These are safe to load without review. Alternates
+// left by Assign are ignored.
+func (l *links) OneToOne() (map[string]string, map[string]string) {
+	oclcCnt := map[string]map[string]bool{}
+	tindCnt := map[string]map[string]bool{}
+	pairs := []*Link{}
+	for _, pair := range l.pairs {
+		if pair.Status == statusAlternate {
+			continue
+		}
+		pairs = append(pairs, pair)
+		if oclcCnt[pair.OCLC] == nil {
+			oclcCnt[pair.OCLC] = map[string]bool{}
+		}
+		oclcCnt[pair.OCLC][pair.Tind] = true
+		if tindCnt[pair.Tind] == nil {
+			tindCnt[pair.Tind] = map[string]bool{}
+		}
+		tindCnt[pair.Tind][pair.OCLC] = true
+	}
+	oclcByTind := map[string]string{}
+	tindByOCLC := map[string]string{}
+	for _, pair := range pairs {
+		if len(oclcCnt[pair.OCLC]) == 1 && len(tindCnt[pair.Tind]) == 1 {
+			oclcByTind[pair.Tind] = pair.OCLC
+			tindByOCLC[pair.OCLC] = pair.Tind
+		}
+	}
+	return oclcByTind, tindByOCLC
+}
+
+// run reconciles the OCLC records (the targets) against the TIND
+// records (the sources)
+type run struct {
+	oclc   []*Record
+	tind   []*Record
+	out    *outputs
+	assign bool
+
+	// checkpointFName, when set, is where the state is saved every
+	// checkpointEvery targets and at the end of each phase
+	checkpointFName string
+	checkpointEvery int
+
+	state       *Checkpoint
+	found       *links
+	matchedTind map[int]bool
+	// newTind holds the TIND records matched since the last checkpoint,
+	// saved counts what of the state's lists the checkpoint holds
+	newTind []int
+	saved   struct {
+		links, matches, unmatched, missing int
+	}
+	tindNo map[*Record]int
+	// pending holds the matched results until the end of the run when assigning
+	pending []*Result
+	// store, when set, keeps the candidate pairs found, storeBatch holds
+	// the pairs waiting to be written
+	store      *Store
+	storeBatch []*storedPair
+	oclcNo     map[*Record]int
+	// decisions holds the review verdicts to honour, tindByID finds the
+	// TIND records of forced links
+	decisions *Decisions
+	tindByID  map[string][]*Record
+	// carried holds the results of an incremental run's previous run
+	// for the OCLC records which don't need matching again
+	carried map[int]*Result
+
+	// timings holds how long each phase run by this process took
+	timings []*PhaseTiming
+	// metrics, when set, are updated as the run goes on
+	metrics *metrics
+
+	startT  time.Time
+	filterT time.Time
+	// phaseT is when this process started the current phase, at row phaseFrom
+	phaseT    time.Time
+	phaseFrom int
+	barT      time.Time
+}
+
+func newRun(oclc, tind []*Record, out *outputs, state *Checkpoint) *run {
+	r := &run{
+		oclc:        oclc,
+		tind:        tind,
+		out:         out,
+		state:       state,
+		found:       &links{pairs: state.Links},
+		matchedTind: map[int]bool{},
+		tindNo:      map[*Record]int{},
+		oclcNo:      map[*Record]int{},
+		startT:      time.Now(),
+	}
+	for i, rec := range tind {
+		r.tindNo[rec] = i
+	}
+	for i, rec := range oclc {
+		r.oclcNo[rec] = i
+	}
+	for _, no := range state.MatchedTind {
+		r.matchedTind[no] = true
+	}
+	r.saved.links, r.saved.matches = len(state.Links), len(state.Matches)
+	r.saved.unmatched, r.saved.missing = len(state.Unmatched), len(state.Missing)
+	if r.state.Pass == 0 {
+		r.state.Pass = phaseExact
+	}
+	return r
+}
+
+func percentage(x, y int) string {
+	if y != 0 {
+		f := (float64(x) / float64(y)) * 100.0
+		return fmt.Sprintf("%3.1f%%", f)
+	}
+	return "0%"
+}
+
+// SetDecisions makes the run honour review decisions
+func (r *run) SetDecisions(ds *Decisions) {
+	r.decisions = ds
+	r.tindByID = map[string][]*Record{}
+	for _, rec := range r.tind {
+		r.tindByID[rec.Tind] = append(r.tindByID[rec.Tind], rec)
+	}
+}
+
+// targets returns the indexes into oclc handled by phase
+func (r *run) targets(phase int) []int {
+	switch phase {
+	case phaseExact:
+		nos := make([]int, len(r.oclc))
+		for i := range nos {
+			nos[i] = i
+		}
+		return nos
+	case phaseLevenshtein:
+		return r.state.Unmatched
+	case phaseUnmatchedOCLC:
+		return r.state.Missing
+	}
+	return nil
+}
+
+func (r *run) emit(res *Result) error {
+	if r.assign == true && len(res.Candidates) > 0 && res.Candidates[0].Status == "" {
+		r.pending = append(r.pending, res)
+		return nil
+	}
+	if err := r.out.Write(res); err != nil {
+		return err
+	}
+	if len(res.Candidates) > 0 {
+		m := &targetMatch{No: r.oclcNo[res.Target]}
+		for _, c := range res.Candidates {
+			m.Passes = append(m.Passes, c.Pass)
+		}
+		r.state.Matches = append(r.state.Matches, m)
+	}
+	for _, c := range res.Candidates {
+		r.found.Add(res.Target.OCLC, c)
+		r.metrics.Matched(c.Pass)
+		if r.store != nil {
+			r.storeBatch = append(r.storeBatch, &storedPair{
+				OCLC:   r.oclcNo[res.Target],
+				Tind:   r.tindNo[c.source],
+				Pass:   c.Pass,
+				Score:  c.Score,
+				Fields: c.Fields,
+				Status: c.Status,
+			})
+		}
+	}
+	if len(r.storeBatch) >= 1000 {
+		return r.flushStore()
+	}
+	return nil
+}
+
+// flushStore writes the pairs waiting in storeBatch
+func (r *run) flushStore() error {
+	if r.store == nil || len(r.storeBatch) == 0 {
+		return nil
+	}
+	if err := r.store.SavePairs(r.storeBatch); err != nil {
+		return err
+	}
+	r.storeBatch = nil
+	return nil
+}
+
+// progress logs every 100 targets, or draws the progress bar, and saves
+// a checkpoint every checkpointEvery targets, i is the position within
+// the current phase
+func (r *run) progress(i, total int, withCounts bool) error {
+	t := time.Now()
+	r.metrics.Progress(i+1, total, r.state.MatchedCnt, r.state.UnmatchedCnt, len(r.tind)-len(r.matchedTind))
+	if showProgress && t.Sub(r.barT) >= 100*time.Millisecond {
+		stderr.Draw(progressBar(phaseNames[r.state.Pass], i+1, total, i+1-r.phaseFrom, t.Sub(r.phaseT)))
+		r.barT = t
+	}
+	if (i % 100) == 0 {
+		// The bar shows progress on a terminal, so these are detail there
+		level := slog.LevelInfo
+		if showProgress {
+			level = slog.LevelDebug
+		}
+		attrs := []any{
+			"phase", phaseNames[r.state.Pass],
+			"rows", i,
+			"total", total,
+			"percent", percentage(i, total),
+		}
+		if withCounts {
+			attrs = append(attrs, "matched", r.state.MatchedCnt, "unmatched", r.state.UnmatchedCnt)
+		}
+		attrs = append(attrs, slog.Duration("batch_time", t.Sub(r.filterT)), runningTime(r.startT))
+		slog.Log(context.Background(), level, "rows processed", attrs...)
+		r.filterT = t
+	}
+	if r.checkpointFName != "" && ((i+1)%r.checkpointEvery) == 0 {
+		r.state.Position = i + 1
+		return r.checkpoint()
+	}
+	return nil
+}
+
+// loaded notes the records took from startT to loadedT to read, reports
+// give it as the first phase and count the run's time from startT
+func (r *run) loaded(startT, loadedT time.Time) {
+	r.startT = startT
+	r.timings = append(r.timings, &PhaseTiming{Phase: loadPhase, Seconds: loadedT.Sub(startT).Seconds()})
+}
+
+// nextPhase moves the run on to phase and saves a checkpoint
+func (r *run) nextPhase(phase int) error {
+	t := time.Now()
+	stderr.ClearBar()
+	r.timings = append(r.timings, &PhaseTiming{Phase: phaseNames[r.state.Pass], Seconds: t.Sub(r.phaseT).Seconds()})
+	r.state.Pass = phase
+	r.state.Position = 0
+	r.filterT = t
+	r.phaseT = t
+	r.phaseFrom = 0
+	r.metrics.Phase(phaseNames[phase])
+	if err := r.flushStore(); err != nil {
+		return err
+	}
+	if r.checkpointFName != "" {
+		return r.checkpoint()
+	}
+	return nil
+}
+
+// scan runs the exact or Levenshtein title pass from the current
+// position. When ctx is cancelled the position is left at the first
+// target not done.
+func (r *run) scan(ctx context.Context, withLevenshtein bool) error {
+	nos := r.targets(r.state.Pass)
+	total := len(nos)
+	for i := r.state.Position; i < total; i++ {
+		if err := ctx.Err(); err != nil {
+			r.state.Position = i
+			return err
+		}
+		no := nos[i]
+		rec := r.oclc[no]
+		var matched []*Candidate
+		carried, isCarried := r.carried[no]
+		if isCarried {
+			matched = carried.Candidates
+			if r.assign == true {
+				//NOTE: carried links are assigned again along with the new ones
+				for _, c := range matched {
+					c.Status = ""
+				}
+			}
+		} else {
+			var err error
+			if matched, err = Scan(ctx, rec, r.tind, withLevenshtein); err != nil {
+				r.state.Position = i
+				return err
+			}
+			r.metrics.Compared(len(r.tind))
+		}
+		if r.decisions != nil {
+			matched = r.decisions.Apply(rec, matched, r.tindByID, withLevenshtein)
+		}
+		if isCarried && len(matched) == 0 {
+			// Unmatched in both passes last time and nothing new matches it
+			r.state.UnmatchedCnt++
+			r.state.Missing = append(r.state.Missing, no)
+			if err := r.progress(i, total, true); err != nil {
+				return err
+			}
+			continue
+		}
+		if len(matched) > 0 {
+			if err := r.emit(&Result{Target: rec, Candidates: matched}); err != nil {
+				return err
+			}
+			for _, c := range matched {
+				if no := r.tindNo[c.source]; r.matchedTind[no] == false {
+					r.matchedTind[no] = true
+					r.newTind = append(r.newTind, no)
+				}
+			}
+			r.state.MatchedCnt++
+		} else {
+			r.state.UnmatchedCnt++
+			if withLevenshtein {
+				r.state.Missing = append(r.state.Missing, no)
+			} else {
+				r.state.Unmatched = append(r.state.Unmatched, no)
+			}
+		}
+		if err := r.progress(i, total, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run carries the run through its remaining phases. Cancelling ctx
+// stops it between targets, returning ctx's error, and what was done can
+// be saved with checkpoint.
+func (r *run) Run(ctx context.Context) error {
+	r.filterT = time.Now()
+	r.phaseT = r.filterT
+	r.phaseFrom = r.state.Position
+	r.metrics.Phase(phaseNames[r.state.Pass])
+	st := r.state
+	if st.Pass == phaseExact {
+		slog.Info("running with simple title matching", runningTime(r.startT))
+		if err := r.scan(ctx, false); err != nil {
+			return err
+		}
+		st.UnmatchedCnt = 0
+		if err := r.nextPhase(phaseLevenshtein); err != nil {
+			return err
+		}
+	}
+	if st.Pass == phaseLevenshtein {
+		slog.Info("running unmatched against Levenshtein title matching", runningTime(r.startT))
+		if err := r.scan(ctx, true); err != nil {
+			return err
+		}
+		if r.assign == true {
+			slog.Info("assigning one-to-one links", runningTime(r.startT))
+			linkCnt := Assign(r.pending)
+			slog.Info("links assigned", "links", linkCnt, "matched", len(r.pending), runningTime(r.startT))
+			for _, res := range r.pending {
+				if err := r.emit(res); err != nil {
+					return err
+				}
+			}
+		}
+		if err := r.nextPhase(phaseUnmatchedOCLC); err != nil {
+			return err
+		}
+	}
+	if st.Pass == phaseUnmatchedOCLC {
+		slog.Info("generating unmatched list (match count 0)", runningTime(r.startT))
+		nos := r.targets(phaseUnmatchedOCLC)
+		for i := st.Position; i < len(nos); i++ {
+			if err := ctx.Err(); err != nil {
+				st.Position = i
+				return err
+			}
+			if err := r.emit(&Result{Target: r.oclc[nos[i]], Candidates: []*Candidate{}}); err != nil {
+				return err
+			}
+			if err := r.progress(i, len(nos), false); err != nil {
+				return err
+			}
+		}
+		if err := r.nextPhase(phaseUnmatchedTind); err != nil {
+			return err
+		}
+	}
+	if st.Pass == phaseUnmatchedTind {
+		if r.out.unmatchedTind != nil {
+			slog.Info("generating unmatched TIND list", runningTime(r.startT))
+			unmatchedTindCnt := 0
+			for i, rec := range r.tind {
+				if r.matchedTind[i] == false {
+					if err := r.out.WriteUnmatchedTind(rec); err != nil {
+						return err
+					}
+					unmatchedTindCnt++
+				}
+			}
+			slog.Info("TIND rows unmatched", "unmatched", unmatchedTindCnt, "total", len(r.tind), runningTime(r.startT))
+		}
+		if err := r.nextPhase(phaseDone); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"flag"
+	"html/template"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+)
+
+// reviewServer serves a side by side review of a run's results
+type reviewServer struct {
+	sync.Mutex
+	queue          []*Result
+	decisions      *Decisions
+	decisionsFName string
+	page           *template.Template
+	// token is put in the review pages and must come back with each
+	// decision, so other sites can't post decisions through the browser
+	token string
+}
+
+type reviewPage struct {
+	Token      string
+	No         int
+	Total      int
+	Prev       int
+	Next       int
+	Target     *Record
+	Candidates []*reviewCandidate
+	Rows       []*reviewRow
+}
+
+type reviewCandidate struct {
+	No      int
+	Tind    string
+	Pass    string
+	Score   int
+	Status  string
+	Verdict string
+}
+
+// reviewHTML is self contained, no scripts or styles are fetched so
+// review works offline
+const reviewHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Review {{.No}} of {{.Total}}, OCLC {{.Target.OCLC}}</title>
+<style>
+body { font-family: sans-serif; margin: 1em; }
+nav a { margin-right: 1em; }
+table { border-collapse: collapse; margin-top: 1em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; vertical-align: top; }
+td.diff { background: #fdd; }
+th.selected { background: #ddf; }
+.accept { color: green; }
+.reject { color: red; }
+.force { color: blue; }
+.help { color: #666; font-size: smaller; }
+</style>
+</head>
+<body>
+<nav>
+<a id="prev" href="/?i={{.Prev}}">&larr; previous</a>
+<strong>{{.No}} of {{.Total}}</strong>
+<a id="next" href="/?i={{.Next}}">next &rarr;</a>
+</nav>
+<table>
+<tr><th>field</th><th>OCLC {{.Target.OCLC}}</th>
+{{- range .Candidates}}
+<th id="c{{.No}}">{{.No}}. TIND {{.Tind}}<br>{{.Pass}}, score {{.Score}}{{if .Status}}, {{.Status}}{{end}}
+{{- if .Verdict}}<br><span class="{{.Verdict}}">{{.Verdict}}</span>{{end}}</th>
+{{- end}}
+</tr>
+{{- range .Rows}}
+<tr><th>{{.Name}}</th><td>{{.Target}}</td>{{range .Values}}<td{{if .Differs}} class="diff"{{end}}>{{.Value}}</td>{{end}}</tr>
+{{- end}}
+<tr><th></th><td></td>
+{{- range .Candidates}}
+<td><form method="post" action="/decide" id="f{{.No}}">
+<input type="hidden" name="token" value="{{$.Token}}">
+<input type="hidden" name="i" value="{{$.No}}">
+<input type="hidden" name="oclc" value="{{$.Target.OCLC}}">
+<input type="hidden" name="tind" value="{{.Tind}}">
+<button name="verdict" value="accept">accept</button>
+<button name="verdict" value="reject">reject</button>
+<button name="verdict" value="force">force</button>
+</form></td>
+{{- end}}
+</tr>
+</table>
+<form method="post" action="/decide">
+<input type="hidden" name="token" value="{{.Token}}">
+<input type="hidden" name="i" value="{{.No}}">
+<input type="hidden" name="oclc" value="{{.Target.OCLC}}">
+<label>TIND id <input name="tind" size="12"></label>
+<button name="verdict" value="force">force a link</button>
+</form>
+<p class="help">Keys: 1-9 pick a candidate, a accept, r reject, f force, n or &rarr; next, p or &larr; previous.
+Force links a TIND record to the OCLC record whether or not they match.</p>
+<script>
+var picked = 1;
+function pick(n) {
+	var th = document.getElementById("c" + n);
+	if (th === null) { return; }
+	var old = document.getElementById("c" + picked);
+	if (old !== null) { old.className = ""; }
+	th.className = "selected";
+	picked = n;
+}
+function decide(verdict) {
+	var form = document.getElementById("f" + picked);
+	if (form === null) { return; }
+	var input = document.createElement("input");
+	input.type = "hidden";
+	input.name = "verdict";
+	input.value = verdict;
+	form.appendChild(input);
+	form.submit();
+}
+pick(1);
+document.addEventListener("keydown", function (e) {
+	if (e.ctrlKey || e.metaKey || e.altKey) { return; }
+	if (e.key >= "1" && e.key <= "9") { pick(parseInt(e.key, 10)); }
+	else if (e.key === "a") { decide("accept"); }
+	else if (e.key === "r") { decide("reject"); }
+	else if (e.key === "f") { decide("force"); }
+	else if (e.key === "n" || e.key === "ArrowRight") { document.getElementById("next").click(); }
+	else if (e.key === "p" || e.key === "ArrowLeft") { document.getElementById("prev").click(); }
+});
+</script>
+</body>
+</html>
+`
+
+// position reads the 1 based position in the queue from the request
+func (rs *reviewServer) position(val string) int {
+	i, err := strconv.Atoi(val)
+	if err != nil || i < 1 {
+		return 1
+	}
+	if i > len(rs.queue) {
+		return len(rs.queue)
+	}
+	return i
+}
+
+func (rs *reviewServer) review(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	if len(rs.queue) == 0 {
+		http.Error(w, "nothing to review", http.StatusNotFound)
+		return
+	}
+	rs.Lock()
+	defer rs.Unlock()
+	i := rs.position(r.URL.Query().Get("i"))
+	res := rs.queue[i-1]
+	page := &reviewPage{
+		Token:  rs.token,
+		No:     i,
+		Total:  len(rs.queue),
+		Prev:   i - 1,
+		Next:   i + 1,
+		Target: res.Target,
+		Rows:   reviewRows(res),
+	}
+	if page.Prev < 1 {
+		page.Prev = 1
+	}
+	if page.Next > page.Total {
+		page.Next = page.Total
+	}
+	for j, c := range res.Candidates {
+		page.Candidates = append(page.Candidates, &reviewCandidate{
+			No:      j + 1,
+			Tind:    c.Record.Tind,
+			Pass:    c.Pass,
+			Score:   c.Score,
+			Status:  c.Status,
+			Verdict: rs.decisions.Verdict(res.Target.OCLC, c.Record.Tind),
+		})
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := rs.page.Execute(w, page); err != nil {
+		slog.Error("can't render review page", "error", err)
+	}
+}
+
+// sameOrigin reports if a request was sent by a page of this server, a
+// browser names the page's origin in the Origin header of a POST
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+func (rs *reviewServer) decide(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "decisions must be posted", http.StatusMethodNotAllowed)
+		return
+	}
+	if sameOrigin(r) == false || subtle.ConstantTimeCompare([]byte(r.PostFormValue("token")), []byte(rs.token)) != 1 {
+		http.Error(w, "decision not posted from the review page, reload it", http.StatusForbidden)
+		return
+	}
+	d := &Decision{
+		OCLC:    r.PostFormValue("oclc"),
+		Tind:    r.PostFormValue("tind"),
+		Verdict: r.PostFormValue("verdict"),
+	}
+	if d.OCLC == "" || d.Tind == "" {
+		http.Error(w, "a decision needs an OCLC number and a TIND id", http.StatusBadRequest)
+		return
+	}
+	rs.Lock()
+	defer rs.Unlock()
+	if err := rs.decisions.Set(d); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := AppendDecision(rs.decisionsFName, d); err != nil {
+		slog.Error("can't save decision", "file", rs.decisionsFName, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.Info("decision", "verdict", d.Verdict, "oclc", d.OCLC, "tind", d.Tind)
+	http.Redirect(w, r, "/?i="+strconv.Itoa(rs.position(r.PostFormValue("i"))), http.StatusSeeOther)
+}
+
+// newToken returns a random token for a review server
+func newToken() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// serve runs the review web UI, "reconcile serve"
+func serve(args []string) {
+	var (
+		addr           string
+		resultsFName   string
+		storeFName     string
+		decisionsFName string
+		only           string
+	)
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	fs.StringVar(&addr, "addr", "localhost:8000", "address to listen on")
+	fs.StringVar(&resultsFName, "results", "", "JSON Lines output of a run (-format jsonl) to review")
+	fs.StringVar(&storeFName, "store", "", "review the pairs of the last run held in this store instead")
+	fs.StringVar(&decisionsFName, "decisions", "decisions.csv", "decisions file to read and append to")
+	fs.StringVar(&only, "only", "uncertain", "results to review, all, ambiguous or uncertain (ambiguous or Levenshtein matched)")
+	logOpts := addLogFlags(fs)
+	fs.Parse(args)
+	if err := logOpts.setup(); err != nil {
+		log.Fatal(err)
+	}
+
+	results, decisions, err := loadReview(resultsFName, storeFName, decisionsFName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	queue, err := reviewQueue(results, only)
+	if err != nil {
+		log.Fatal(err)
+	}
+	token, err := newToken()
+	if err != nil {
+		log.Fatal(err)
+	}
+	rs := &reviewServer{
+		queue:          queue,
+		decisions:      decisions,
+		decisionsFName: decisionsFName,
+		page:           template.Must(template.New("review").Parse(reviewHTML)),
+		token:          token,
+	}
+	http.HandleFunc("/", rs.review)
+	http.HandleFunc("/decide", rs.decide)
+	slog.Info("results to review", "queue", len(queue), "results", len(results), "url", "http://"+addr+"/")
+	log.Fatal(http.ListenAndServe(addr, nil))
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReviewServerDecide(t *testing.T) {
+	target, source := testRecord(), testRecord()
+	target.OCLC, source.Tind = "101", "2001"
+	rs := &reviewServer{
+		queue:          []*Result{{Target: target, Candidates: []*Candidate{{Record: Merge(target, source), Pass: passExact, source: source}}}},
+		decisions:      NewDecisions(),
+		decisionsFName: filepath.Join(t.TempDir(), "decisions.csv"),
+		page:           template.Must(template.New("review").Parse(reviewHTML)),
+		token:          "secret",
+	}
+	w := httptest.NewRecorder()
+	rs.review(w, httptest.NewRequest(http.MethodGet, "/?i=1", nil))
+	if page := w.Body.String(); strings.Contains(page, `name="token" value="secret"`) == false {
+		t.Fatalf("review page doesn't carry the token")
+	}
+
+	post := func(form url.Values, origin string) int {
+		r := httptest.NewRequest(http.MethodPost, "http://localhost:8000/decide", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		w := httptest.NewRecorder()
+		rs.decide(w, r)
+		return w.Code
+	}
+	form := func(token, tind, verdict string) url.Values {
+		return url.Values{"token": {token}, "i": {"1"}, "oclc": {"101"}, "tind": {tind}, "verdict": {verdict}}
+	}
+	for _, tc := range []struct {
+		name   string
+		form   url.Values
+		origin string
+		want   int
+	}{
+		{"no token", form("", "2001", verdictReject), "", http.StatusForbidden},
+		{"wrong token", form("guess", "2001", verdictReject), "", http.StatusForbidden},
+		{"other site", form("secret", "2001", verdictReject), "http://example.com", http.StatusForbidden},
+		{"no TIND id", form("secret", "", verdictForce), "", http.StatusBadRequest},
+		{"unknown verdict", form("secret", "2001", "maybe"), "", http.StatusBadRequest},
+		{"accept", form("secret", "2001", verdictAccept), "http://localhost:8000", http.StatusSeeOther},
+		{"force", form("secret", "2002", verdictForce), "", http.StatusSeeOther},
+	} {
+		if got := post(tc.form, tc.origin); got != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, got, tc.want)
+		}
+	}
+	if v := rs.decisions.Verdict("101", "2001"); v != verdictAccept {
+		t.Errorf("101/2001 verdict %q, want accept", v)
+	}
+	if forced := rs.decisions.Forced("101"); len(forced) != 1 || forced[0] != "2002" {
+		t.Errorf("101 forced onto %v, want 2002", forced)
+	}
+	saved, err := LoadDecisions(rs.decisionsFName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.All()) != 2 {
+		t.Errorf("%d decisions saved, want 2", len(saved.All()))
+	}
+}
+
+func TestLoadReview(t *testing.T) {
+	dName := t.TempDir()
+	storeFName := filepath.Join(dName, "reconcile.db")
+	decisionsFName := filepath.Join(dName, "decisions.csv")
+	store, err := OpenStore(storeFName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, source := testRecord(), testRecord()
+	target.OCLC, source.Tind = "101", "2001"
+	held := NewDecisions()
+	for _, d := range []*Decision{
+		{OCLC: "101", Tind: "2001", Verdict: verdictAccept, Updated: "2024-05-01T10:00:00Z"},
+		{OCLC: "102", Tind: "2002", Verdict: verdictReject, Updated: "2024-05-01T10:00:00Z"},
+	} {
+		if err := held.Set(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, err := range []error{
+		store.SaveRecords("oclc", []*Record{target}),
+		store.SaveRecords("tind", []*Record{source}),
+		store.SavePairs([]*storedPair{{OCLC: 0, Tind: 0, Pass: passExact}}),
+		store.SaveDecisions(held),
+		store.Close(),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := AppendDecision(decisionsFName, &Decision{OCLC: "101", Tind: "2001", Verdict: verdictReject, Updated: "2024-05-02T10:00:00Z"}); err != nil {
+		t.Fatal(err)
+	}
+
+	results, decisions, err := loadReview("", storeFName, decisionsFName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || len(results[0].Candidates) != 1 {
+		t.Errorf("results from the store %v, want the one pair", results)
+	}
+	if v := decisions.Verdict("101", "2001"); v != verdictReject {
+		t.Errorf("101/2001 loaded as %q, want the newer reject from the file", v)
+	}
+	if v := decisions.Verdict("102", "2002"); v != verdictReject {
+		t.Errorf("102/2002 loaded as %q, want the reject held in the store", v)
+	}
+	// A run can open the store once the review has loaded
+	store, err = OpenStore(storeFName)
+	if err != nil {
+		t.Fatalf("store still held after loading the review, %s", err)
+	}
+	store.Close()
+}
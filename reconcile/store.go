@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	// 3rd Party Packages
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// Buckets of the store, records are keyed by their position in the
+	// export so they come back in the order read
+	oclcBucket  = []byte("oclc")
+	tindBucket  = []byte("tind")
+	keysBucket  = []byte("keys")
+	pairsBucket = []byte("pairs")
+)
+
+// Store is an optional embedded on-disk store holding the records read
+// from both exports, their blocking keys and the candidate pairs found
+// by the last run, so later runs, reports, reviews and dedupes can skip
+// parsing the exports again.
+type Store struct {
+	db *bolt.DB
+}
+
+// storedPair is a candidate pair as kept in the store, the records are
+// referred to by their position in the store
+type storedPair struct {
+	OCLC   int             `json:"oclc"`
+	Tind   int             `json:"tind"`
+	Pass   string          `json:"pass"`
+	Score  int             `json:"score"`
+	Fields map[string]bool `json:"fields"`
+	Status string          `json:"status,omitempty"`
+}
+
+// OpenStore opens or creates the store in fName
+func OpenStore(fName string) (*Store, error) {
+	db, err := bolt.Open(fName, 0664, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{oclcBucket, tindBucket, keysBucket, pairsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+// OpenStoreReadOnly opens an existing store for reading, it shares the
+// file with other readers but waits on a run writing to it
+func OpenStoreReadOnly(fName string) (*Store, error) {
+	db, err := bolt.Open(fName, 0664, &bolt.Options{Timeout: 5 * time.Second, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+// Close closes the store
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+func storeKey(no int) []byte {
+	return []byte(fmt.Sprintf("%010d", no))
+}
+
+// sideBucket returns the bucket for "oclc" or "tind" records
+func sideBucket(side string) []byte {
+	if side == "oclc" {
+		return oclcBucket
+	}
+	return tindBucket
+}
+
+// resetBucket empties a bucket by dropping and recreating it
+func resetBucket(tx *bolt.Tx, name []byte) (*bolt.Bucket, error) {
+	if tx.Bucket(name) != nil {
+		if err := tx.DeleteBucket(name); err != nil {
+			return nil, err
+		}
+	}
+	return tx.CreateBucketIfNotExists(name)
+}
+
+// SaveRecords replaces the records of side ("oclc" or "tind") and their
+// blocking keys
+func (s *Store) SaveRecords(side string, recs []*Record) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := resetBucket(tx, sideBucket(side))
+		if err != nil {
+			return err
+		}
+		keys := tx.Bucket(keysBucket)
+		prefix := []byte(side + "/")
+		c := keys.Cursor()
+		stale := [][]byte{}
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			stale = append(stale, append([]byte{}, k...))
+		}
+		for _, k := range stale {
+			if err := keys.Delete(k); err != nil {
+				return err
+			}
+		}
+		for i, rec := range recs {
+			src, err := json.Marshal(rec)
+			if err != nil {
+				return err
+			}
+			if err := b.Put(storeKey(i), src); err != nil {
+				return err
+			}
+			for _, key := range blockingKeys(rec) {
+				if err := keys.Put([]byte(fmt.Sprintf("%s/%s/%s", side, key, storeKey(i))), storeKey(i)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Blocks returns the positions of the records of side under each of
+// their blocking keys, see blockingKeys
+func (s *Store) Blocks(side string) (map[string][]int, error) {
+	blocks := map[string][]int{}
+	prefix := []byte(side + "/")
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(keysBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			no, err := strconv.Atoi(string(v))
+			if err != nil {
+				return fmt.Errorf("blocking key %s, %s", k, err)
+			}
+			key := strings.TrimPrefix(string(k), string(prefix))
+			key = key[:strings.LastIndex(key, "/")]
+			blocks[key] = append(blocks[key], no)
+		}
+		return nil
+	})
+	return blocks, err
+}
+
+// Records returns the records of side in the order they were saved
+func (s *Store) Records(side string) ([]*Record, error) {
+	recs := []*Record{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(sideBucket(side)).ForEach(func(k, v []byte) error {
+			rec := new(Record)
+			if err := json.Unmarshal(v, rec); err != nil {
+				return fmt.Errorf("%s %s, %s", side, k, err)
+			}
+			recs = append(recs, rec)
+			return nil
+		})
+	})
+	return recs, err
+}
+
+// ClearPairs drops the candidate pairs of an earlier run
+func (s *Store) ClearPairs() error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		_, err := resetBucket(tx, pairsBucket)
+		return err
+	})
+}
+
+// SavePairs adds candidate pairs in one transaction
+func (s *Store) SavePairs(pairs []*storedPair) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(pairsBucket)
+		for _, p := range pairs {
+			src, err := json.Marshal(p)
+			if err != nil {
+				return err
+			}
+			key := fmt.Sprintf("%s/%s", storeKey(p.OCLC), storeKey(p.Tind))
+			if err := b.Put([]byte(key), src); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Results rebuilds the matched results of the last run from the store,
+// in OCLC record order
+func (s *Store) Results() ([]*Result, error) {
+	oclc, err := s.Records("oclc")
+	if err != nil {
+		return nil, err
+	}
+	tind, err := s.Records("tind")
+	if err != nil {
+		return nil, err
+	}
+	results := []*Result{}
+	var res *Result
+	err = s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(pairsBucket).ForEach(func(k, v []byte) error {
+			p := new(storedPair)
+			if err := json.Unmarshal(v, p); err != nil {
+				return fmt.Errorf("pair %s, %s", k, err)
+			}
+			if p.OCLC >= len(oclc) || p.Tind >= len(tind) {
+				return fmt.Errorf("pair %s refers to a missing record", k)
+			}
+			target, source := oclc[p.OCLC], tind[p.Tind]
+			if res == nil || res.Target != target {
+				res = &Result{Target: target, Candidates: []*Candidate{}}
+				results = append(results, res)
+			}
+			res.Candidates = append(res.Candidates, &Candidate{
+				Record: Merge(target, source),
+				Pass:   p.Pass,
+				Score:  p.Score,
+				Fields: p.Fields,
+				Status: p.Status,
+				source: source,
+			})
+			return nil
+		})
+	})
+	for _, res := range results {
+		for _, c := range res.Candidates {
+			c.Record.MatchedCount = len(res.Candidates)
+		}
+	}
+	return results, err
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestStoreRecords(t *testing.T) {
+	fName := filepath.Join(t.TempDir(), "reconcile.db")
+	store, err := OpenStore(fName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	recs := []*Record{testRecord(), testRecord()}
+	recs[0].OCLC, recs[1].OCLC = "101", "102"
+	recs[1].Title, recs[1].ISBN = "Principles of heat", ""
+	if err := store.SaveRecords("oclc", recs); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveRecords("oclc", recs[1:]); err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.Records("oclc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].OCLC != "102" {
+		t.Errorf("records after replacing them %v", got)
+	}
+	blocks, err := store.Blocks("oclc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := blockIndex(recs[1:]); reflect.DeepEqual(blocks, want) == false {
+		t.Errorf("blocks after replacing the records %v, want %v", blocks, want)
+	}
+	if got, _ = store.Records("tind"); len(got) != 0 {
+		t.Errorf("%d TIND records in a store holding none", len(got))
+	}
+}
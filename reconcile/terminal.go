@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	// ANSI escapes used by the terminal review
+	ansiClear      = "\x1b[H\x1b[2J"
+	ansiHideCursor = "\x1b[?25l"
+	ansiShowCursor = "\x1b[?25h"
+	ansiReverse    = "\x1b[7m"
+	ansiBold       = "\x1b[1m"
+	ansiRed        = "\x1b[31m"
+	ansiGreen      = "\x1b[32m"
+	ansiBlue       = "\x1b[34m"
+	ansiReset      = "\x1b[0m"
+
+	// fieldWidth is the width of the column of field names
+	fieldWidth = 15
+	// minCellWidth is the narrowest a record's column gets before
+	// candidates are paged
+	minCellWidth = 16
+)
+
+// reviewTerminal pages through a run's results in a terminal, it works
+// over SSH and needs nothing beyond stty
+type reviewTerminal struct {
+	queue          []*Result
+	decisions      *Decisions
+	decisionsFName string
+	in             *bufio.Reader
+	out            io.Writer
+	// raw is true when keys are read one at a time and the screen
+	// redrawn, otherwise commands are read a line at a time
+	raw    bool
+	width  int
+	i      int
+	picked int
+	status string
+	// digits holds a candidate number being typed in raw mode, needed
+	// when there are more than nine candidates
+	digits string
+}
+
+// stty runs stty against the terminal on stdin
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// isTerminal reports if fp is a terminal rather than a file or pipe
+func isTerminal(fp *os.File) bool {
+	info, err := fp.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// terminalWidth returns the number of columns of the terminal, 80 when
+// it can't be found
+func terminalWidth() int {
+	if size, err := stty("size"); err == nil {
+		if parts := strings.Fields(size); len(parts) == 2 {
+			if cols, err := strconv.Atoi(parts[1]); err == nil && cols > 0 {
+				return cols
+			}
+		}
+	}
+	return 80
+}
+
+// cell pads or cuts s to width runes
+func cell(s string, width int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	r := []rune(s)
+	if len(r) > width {
+		return string(r[:width-1]) + "…"
+	}
+	return s + strings.Repeat(" ", width-len(r))
+}
+
+// verdictColor picks the color a verdict is shown in
+func verdictColor(verdict string) string {
+	switch verdict {
+	case verdictAccept:
+		return ansiGreen
+	case verdictReject:
+		return ansiRed
+	case verdictForce:
+		return ansiBlue
+	}
+	return ""
+}
+
+// color wraps s in an ANSI color when the screen is redrawn, line mode
+// output is plain so it reads well in a log or a dumb terminal
+func (rt *reviewTerminal) color(code, s string) string {
+	if rt.raw == false || code == "" {
+		return s
+	}
+	return code + s + ansiReset
+}
+
+// window returns the candidates of res which fit beside the target,
+// paged so the picked candidate is shown
+func (rt *reviewTerminal) window(res *Result) (int, int, int) {
+	fit := (rt.width - fieldWidth) / (minCellWidth + 1)
+	if fit < 2 {
+		fit = 2
+	}
+	// One column is the target's
+	fit--
+	start := ((rt.picked - 1) / fit) * fit
+	end := start + fit
+	if end > len(res.Candidates) {
+		end = len(res.Candidates)
+	}
+	cols := end - start + 1
+	width := (rt.width - fieldWidth) / cols
+	if width < minCellWidth {
+		width = minCellWidth
+	}
+	return start, end, width - 1
+}
+
+// render shows the current result, target and candidates side by side
+// with the fields differing from the target marked
+func (rt *reviewTerminal) render() {
+	res := rt.queue[rt.i]
+	start, end, width := rt.window(res)
+	var b strings.Builder
+	if rt.raw == true {
+		b.WriteString(ansiClear)
+	}
+	fmt.Fprintf(&b, "%s\n\n", rt.color(ansiBold, fmt.Sprintf("Review %d of %d, OCLC %s, %d candidates",
+		rt.i+1, len(rt.queue), res.Target.OCLC, len(res.Candidates))))
+
+	fmt.Fprintf(&b, "%s %s", cell("", fieldWidth-1), cell("OCLC "+res.Target.OCLC, width))
+	for j := start; j < end; j++ {
+		c := res.Candidates[j]
+		head := cell(fmt.Sprintf("%d. TIND %s", j+1, c.Record.Tind), width)
+		if j+1 == rt.picked {
+			head = rt.color(ansiReverse, head)
+		}
+		fmt.Fprintf(&b, " %s", head)
+	}
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "%s %s", cell("", fieldWidth-1), cell("", width))
+	for j := start; j < end; j++ {
+		c := res.Candidates[j]
+		about := fmt.Sprintf("%s, score %d", c.Pass, c.Score)
+		if c.Status != "" {
+			about += ", " + c.Status
+		}
+		fmt.Fprintf(&b, " %s", cell(about, width))
+	}
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "%s %s", cell("", fieldWidth-1), cell("", width))
+	for j := start; j < end; j++ {
+		c := res.Candidates[j]
+		verdict := rt.decisions.Verdict(res.Target.OCLC, c.Record.Tind)
+		fmt.Fprintf(&b, " %s", rt.color(verdictColor(verdict), cell(verdict, width)))
+	}
+	b.WriteString("\n")
+
+	for _, row := range reviewRows(res) {
+		fmt.Fprintf(&b, "%s %s", cell(row.Name, fieldWidth-1), cell(row.Target, width))
+		for j := start; j < end; j++ {
+			val := row.Values[j]
+			text := cell(val.Value, width)
+			if val.Differs {
+				if rt.raw == true {
+					text = rt.color(ansiRed, text)
+				} else {
+					// Without color mark the difference in the text
+					text = cell("*"+val.Value, width)
+				}
+			}
+			fmt.Fprintf(&b, " %s", text)
+		}
+		b.WriteString("\n")
+	}
+	if start > 0 || end < len(res.Candidates) {
+		fmt.Fprintf(&b, "\nShowing candidates %d-%d of %d\n", start+1, end, len(res.Candidates))
+	}
+	if rt.status != "" {
+		fmt.Fprintf(&b, "\n%s\n", rt.status)
+		rt.status = ""
+	}
+	if rt.raw == true {
+		pick := "1-9 pick"
+		if len(res.Candidates) > 9 {
+			pick = fmt.Sprintf("1-%d Enter pick", len(res.Candidates))
+		}
+		fmt.Fprintf(&b, "\n%s, a accept, r reject, n/→ next, p/← previous, q quit", pick)
+		if rt.digits != "" {
+			fmt.Fprintf(&b, "  pick %s_", rt.digits)
+		}
+	} else {
+		fmt.Fprintf(&b, "\n[1-%d pick (%d), a accept, r reject, n next, p previous, q quit] ", len(res.Candidates), rt.picked)
+	}
+	io.WriteString(rt.out, b.String())
+}
+
+// decide records a verdict on the picked candidate
+func (rt *reviewTerminal) decide(verdict string) {
+	res := rt.queue[rt.i]
+	c := res.Candidates[rt.picked-1]
+	d := &Decision{
+		OCLC:    res.Target.OCLC,
+		Tind:    c.Record.Tind,
+		Verdict: verdict,
+	}
+	if err := rt.decisions.Set(d); err != nil {
+		rt.status = err.Error()
+		return
+	}
+	if err := AppendDecision(rt.decisionsFName, d); err != nil {
+		rt.status = fmt.Sprintf("Can't save decision to %s, %s", rt.decisionsFName, err)
+		return
+	}
+	rt.status = fmt.Sprintf("%s OCLC %s, TIND %s", d.Verdict, d.OCLC, d.Tind)
+}
+
+// move goes to the result at i, staying within the queue
+func (rt *reviewTerminal) move(i int) {
+	if i < 0 || i >= len(rt.queue) {
+		return
+	}
+	rt.i, rt.picked = i, 1
+}
+
+// command acts on a key or line, returning false to quit
+func (rt *reviewTerminal) command(cmd string) bool {
+	switch cmd {
+	case "q", "\x03", "\x04":
+		return false
+	case "a":
+		rt.decide(verdictAccept)
+	case "r":
+		rt.decide(verdictReject)
+	case "n", " ", "", "\r", "\n", "\x1b[C":
+		rt.move(rt.i + 1)
+	case "p", "\x1b[D":
+		rt.move(rt.i - 1)
+	default:
+		if n, err := strconv.Atoi(cmd); err == nil {
+			if n >= 1 && n <= len(rt.queue[rt.i].Candidates) {
+				rt.picked = n
+			} else {
+				rt.status = fmt.Sprintf("No candidate %d", n)
+			}
+		}
+	}
+	return true
+}
+
+// key turns a key press in raw mode into a command, ok is false while a
+// candidate number is being typed. With up to nine candidates a digit
+// picks at once, with more the digits are collected until Enter.
+func (rt *reviewTerminal) key(k string) (string, bool) {
+	isDigit := len(k) == 1 && k[0] >= '0' && k[0] <= '9'
+	switch {
+	case isDigit && (rt.digits != "" || len(rt.queue[rt.i].Candidates) > 9):
+		rt.digits += k
+		return "", false
+	case rt.digits != "" && (k == "\r" || k == "\n"):
+		cmd := rt.digits
+		rt.digits = ""
+		return cmd, true
+	case rt.digits != "" && (k == "\x7f" || k == "\b"):
+		rt.digits = rt.digits[:len(rt.digits)-1]
+		return "", false
+	}
+	// Any other key drops a number part typed
+	rt.digits = ""
+	return k, true
+}
+
+// readKey reads one key press, arrow keys arrive as escape sequences
+func (rt *reviewTerminal) readKey() (string, error) {
+	b, err := rt.in.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	if b != 0x1b || rt.in.Buffered() < 2 {
+		return string(b), nil
+	}
+	seq := []byte{b}
+	for rt.in.Buffered() > 0 && len(seq) < 3 {
+		next, _ := rt.in.ReadByte()
+		seq = append(seq, next)
+	}
+	return string(seq), nil
+}
+
+// readLine reads one command in line mode
+func (rt *reviewTerminal) readLine() (string, error) {
+	line, err := rt.in.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// Run pages through the queue until the reviewer quits or input ends
+func (rt *reviewTerminal) Run() {
+	for {
+		rt.render()
+		var (
+			cmd string
+			err error
+		)
+		ok := true
+		if rt.raw == true {
+			if cmd, err = rt.readKey(); err == nil {
+				cmd, ok = rt.key(cmd)
+			}
+		} else {
+			cmd, err = rt.readLine()
+		}
+		if ok == false {
+			continue
+		}
+		if err != nil || rt.command(cmd) == false {
+			fmt.Fprintln(rt.out)
+			return
+		}
+	}
+}
+
+// review runs the terminal review, "reconcile review"
+func review(args []string) {
+	var (
+		resultsFName   string
+		storeFName     string
+		decisionsFName string
+		only           string
+		lineMode       bool
+	)
+	fs := flag.NewFlagSet("review", flag.ExitOnError)
+	fs.StringVar(&resultsFName, "results", "", "JSON Lines output of a run (-format jsonl) to review")
+	fs.StringVar(&storeFName, "store", "", "review the pairs of the last run held in this store instead")
+	fs.StringVar(&decisionsFName, "decisions", "decisions.csv", "decisions file to read and append to")
+	fs.StringVar(&only, "only", "uncertain", "results to review, all, ambiguous or uncertain (ambiguous or Levenshtein matched)")
+	fs.BoolVar(&lineMode, "line", false, "read commands a line at a time instead of redrawing the screen")
+	logOpts := addLogFlags(fs)
+	fs.Parse(args)
+	if err := logOpts.setup(); err != nil {
+		log.Fatal(err)
+	}
+
+	results, decisions, err := loadReview(resultsFName, storeFName, decisionsFName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	queue, err := reviewQueue(results, only)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(queue) == 0 {
+		slog.Info("nothing to review", "results", len(results))
+		return
+	}
+	rt := &reviewTerminal{
+		queue:          queue,
+		decisions:      decisions,
+		decisionsFName: decisionsFName,
+		in:             bufio.NewReader(os.Stdin),
+		out:            os.Stdout,
+		width:          80,
+		picked:         1,
+	}
+	if isTerminal(os.Stdin) == true {
+		rt.width = terminalWidth()
+		if lineMode == false {
+			if saved, err := stty("-g"); err == nil {
+				if _, err := stty("-icanon", "-echo", "min", "1"); err == nil {
+					rt.raw = true
+					restore := func() {
+						stty(saved)
+						fmt.Fprint(rt.out, ansiShowCursor)
+					}
+					defer restore()
+					// Put the terminal back if we're killed part way
+					sigs := make(chan os.Signal, 1)
+					signal.Notify(sigs, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+					go func() {
+						<-sigs
+						restore()
+						os.Exit(1)
+					}()
+					fmt.Fprint(rt.out, ansiHideCursor)
+				}
+			}
+		}
+	}
+	rt.Run()
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testTerminal returns a raw mode review of one OCLC record with n
+// candidates, reading keys from keys
+func testTerminal(t *testing.T, n int, keys string) *reviewTerminal {
+	target := testRecord()
+	target.OCLC = "101"
+	res := &Result{Target: target}
+	for i := 1; i <= n; i++ {
+		source := testRecord()
+		source.Tind = fmt.Sprintf("%d", 2000+i)
+		res.Candidates = append(res.Candidates, &Candidate{Record: Merge(target, source), Pass: passExact, source: source})
+	}
+	return &reviewTerminal{
+		queue:          []*Result{res},
+		decisions:      NewDecisions(),
+		decisionsFName: filepath.Join(t.TempDir(), "decisions.csv"),
+		in:             bufio.NewReader(strings.NewReader(keys)),
+		out:            io.Discard,
+		raw:            true,
+		width:          80,
+		picked:         1,
+	}
+}
+
+func TestReviewTerminalPick(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		candidates int
+		keys       string
+		want       int
+	}{
+		{"a digit picks at once", 3, "3", 3},
+		{"zero picks nothing", 3, "0", 1},
+		{"past the candidates", 3, "7", 1},
+		{"two digits then Enter", 12, "12\r", 12},
+		{"one digit then Enter", 12, "7\n", 7},
+		{"digits wait for Enter", 12, "12", 1},
+		{"backspace", 12, "13\x7f1\r", 11},
+		{"another key drops the digits", 12, "12x\r", 1},
+		{"number past the candidates", 12, "13\r", 1},
+	} {
+		rt := testTerminal(t, tc.candidates, tc.keys)
+		rt.Run()
+		if rt.picked != tc.want {
+			t.Errorf("%s: picked %d, want %d", tc.name, rt.picked, tc.want)
+		}
+	}
+
+	rt := testTerminal(t, 12, "11\ra")
+	rt.Run()
+	if v := rt.decisions.Verdict("101", "2011"); v != verdictAccept {
+		t.Errorf("candidate 11 verdict %q, want accept", v)
+	}
+}
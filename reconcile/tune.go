@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caltechlibrary/datatools"
+)
+
+// TunePoint is one config tried by Tune and how it did
+type TunePoint struct {
+	Config     *MatchConfig
+	Evaluation *Evaluation
+}
+
+// tunePair is a target and a source some config swept could match,
+// with which fields agree and their title distance
+type tunePair struct {
+	source   *Record
+	agree    uint16
+	distance int
+}
+
+// tuner evaluates configs over a labeled sample. Only the pairs some
+// config swept could match are kept, for each the fields which agree
+// and the title distance are worked out once, so each config costs
+// little more than a walk over those pairs and memory grows with them
+// rather than with targets times sources.
+type tuner struct {
+	es *evalSet
+	// pairs[i] are the pairs of es.targets[i], in source order
+	pairs  [][]tunePair
+	points []*TunePoint
+}
+
+// reach is the loosest of the configs opts sweeps and the built in
+// matcher: the lowest threshold, the highest weight, the greatest
+// distance and the lowest similarity cutoff above zero
+func (opts *TuneOptions) reach() (int, int, int, float64) {
+	mc := DefaultMatchConfig()
+	threshold, weight, distance, cutoff := mc.Threshold, 1, mc.Levenshtein, 0.0
+	for _, t := range opts.Thresholds {
+		if t < threshold {
+			threshold = t
+		}
+	}
+	for _, w := range opts.Weights {
+		if w > weight {
+			weight = w
+		}
+	}
+	for _, d := range opts.Distances {
+		if d > distance {
+			distance = d
+		}
+	}
+	for _, c := range opts.Similarities {
+		if c > 0 && (cutoff == 0 || c < cutoff) {
+			cutoff = c
+		}
+	}
+	return threshold, weight, distance, cutoff
+}
+
+// countBits counts the fields set in an agreement
+func countBits(agree uint16) int {
+	cnt := 0
+	for ; agree != 0; agree &= agree - 1 {
+		cnt++
+	}
+	return cnt
+}
+
+func newTuner(ctx context.Context, es *evalSet, sources []*Record, opts *TuneOptions) (*tuner, error) {
+	threshold, weight, distance, cutoff := opts.reach()
+	lengths := make([]int, len(sources))
+	for j, source := range sources {
+		lengths[j] = len([]rune(strings.ToLower(source.Title)))
+	}
+	tu := &tuner{es: es, pairs: make([][]tunePair, len(es.targets))}
+	kept := 0
+	for i, target := range es.targets {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		length := len([]rune(strings.ToLower(target.Title)))
+		trimmed := strings.TrimSpace(target.Title)
+		for j, source := range sources {
+			agree := agreement(target, source)
+			// No config swept scores the pair above its threshold
+			if countBits(agree)*weight <= threshold {
+				continue
+			}
+			sameTitle := trimmed == strings.TrimSpace(source.Title)
+			// Titles differ by at least their difference in length
+			apart, longest := length-lengths[j], length
+			if apart < 0 {
+				apart, longest = -apart, lengths[j]
+			}
+			if sameTitle == false && apart > distance && (cutoff == 0 || float64(apart) > (1-cutoff)*float64(longest)) {
+				continue
+			}
+			d := datatools.Levenshtein(target.Title, source.Title, 1, 1, 1, false)
+			if sameTitle || d <= distance || (cutoff > 0 && similarity(d, target.Title, source.Title) >= cutoff) {
+				tu.pairs[i] = append(tu.pairs[i], tunePair{source: source, agree: agree, distance: d})
+				kept++
+			}
+		}
+	}
+	slog.Debug("pairs a config swept could match", "pairs", kept, "targets", len(es.targets), "sources", len(sources))
+	return tu, nil
+}
+
+// predict is predict for mc using the pairs kept
+func (tu *tuner) predict(mc *MatchConfig) map[string]*prediction {
+	predicted := map[string]*prediction{}
+	for i, target := range tu.es.targets {
+		for _, withLevenshtein := range []bool{false, true} {
+			found := 0
+			for _, p := range tu.pairs[i] {
+				score := mc.weigh(p.agree)
+				if score <= mc.Threshold {
+					continue
+				}
+				pass := mc.titlePass(target.Title, p.source.Title, withLevenshtein, func() int {
+					return p.distance
+				})
+				if pass == "" {
+					continue
+				}
+				predicted[pairKey(target.OCLC, p.source.Tind)] = &prediction{
+					oclcID: target.OCLC,
+					c:      &Candidate{Pass: pass, Score: score, source: p.source},
+				}
+				found++
+			}
+			if found > 0 {
+				break
+			}
+		}
+	}
+	return predicted
+}
+
+// try evaluates mc and keeps the result as a point of the curve
+func (tu *tuner) try(mc *MatchConfig) *TunePoint {
+	p := &TunePoint{Config: mc, Evaluation: tu.es.score(mc, tu.predict(mc))}
+	//NOTE: the curve only needs the counts, loose configs list far too
+	// many wrong pairs to keep for every point
+	p.Evaluation.FalsePositive, p.Evaluation.FalseNegative = nil, nil
+	tu.points = append(tu.points, p)
+	slog.Debug("tried config", "matcher", mc.String(), "precision", p.Evaluation.Precision, "recall", p.Evaluation.Recall, "f1", p.Evaluation.F1)
+	return p
+}
+
+// better reports if a beats b, on F1 and then on precision
+func better(a, b *TunePoint) bool {
+	if a.Evaluation.F1 != b.Evaluation.F1 {
+		return a.Evaluation.F1 > b.Evaluation.F1
+	}
+	return a.Evaluation.Precision > b.Evaluation.Precision
+}
+
+// TuneOptions are the values Tune sweeps
+type TuneOptions struct {
+	Thresholds   []int
+	Distances    []int
+	Similarities []float64
+	Weights      []int
+}
+
+// Tune sweeps the matcher parameters over the labeled pairs and returns
+// the best config and every point tried. Thresholds, distances and
+// similarity cutoffs are tried in every combination, then each field's
+// weight in turn keeping those that help, and finally the thresholds
+// again as the weights change what a score means.
+func Tune(ctx context.Context, opts *TuneOptions, labels []*Label, oclc, tind []*Record, complete bool) (*TunePoint, []*TunePoint, error) {
+	tu, err := newTuner(ctx, newEvalSet(labels, oclc, tind, complete), tind, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	best := tu.try(DefaultMatchConfig())
+	for _, threshold := range opts.Thresholds {
+		for _, d := range opts.Distances {
+			for _, cutoff := range opts.Similarities {
+				if err := ctx.Err(); err != nil {
+					return nil, nil, err
+				}
+				mc := DefaultMatchConfig()
+				mc.Threshold, mc.Levenshtein, mc.Similarity = threshold, d, cutoff
+				if p := tu.try(mc); better(p, best) {
+					best = p
+				}
+			}
+		}
+	}
+	for _, field := range scoreFields {
+		for _, w := range opts.Weights {
+			if w == best.Config.weight(field) {
+				continue
+			}
+			if err := ctx.Err(); err != nil {
+				return nil, nil, err
+			}
+			mc := best.Config.copy()
+			mc.Weights[field] = w
+			if p := tu.try(mc); better(p, best) {
+				best = p
+			}
+		}
+	}
+	for _, threshold := range opts.Thresholds {
+		if threshold == best.Config.Threshold {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		mc := best.Config.copy()
+		mc.Threshold = threshold
+		if p := tu.try(mc); better(p, best) {
+			best = p
+		}
+	}
+	// Only weights other than one need saving
+	for field, w := range best.Config.Weights {
+		if w == 1 {
+			delete(best.Config.Weights, field)
+		}
+	}
+	return best, tu.points, nil
+}
+
+// WriteCurve writes the points tried as a CSV file ordered by recall,
+// the precision/recall curve of the sweep
+func WriteCurve(fName string, points []*TunePoint) error {
+	fp, err := os.Create(fName)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	sorted := append([]*TunePoint{}, points...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Evaluation.Recall != sorted[j].Evaluation.Recall {
+			return sorted[i].Evaluation.Recall < sorted[j].Evaluation.Recall
+		}
+		return sorted[i].Evaluation.Precision > sorted[j].Evaluation.Precision
+	})
+	w := csv.NewWriter(fp)
+	w.Write([]string{"threshold", "levenshtein", "similarity", "weights", "precision", "recall", "f1", "true_positives", "false_positives", "false_negatives"})
+	for _, p := range sorted {
+		mc, ev := p.Config, p.Evaluation
+		w.Write([]string{
+			strconv.Itoa(mc.Threshold),
+			strconv.Itoa(mc.Levenshtein),
+			strconv.FormatFloat(mc.Similarity, 'f', -1, 64),
+			mc.weightsString(),
+			strconv.FormatFloat(ev.Precision, 'f', 4, 64),
+			strconv.FormatFloat(ev.Recall, 'f', 4, 64),
+			strconv.FormatFloat(ev.F1, 'f', 4, 64),
+			strconv.Itoa(ev.TruePositives),
+			strconv.Itoa(ev.FalsePositives),
+			strconv.Itoa(ev.FalseNegatives),
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// parseInts reads a comma separated list of integers
+func parseInts(val string) ([]int, error) {
+	ints := []int{}
+	for _, s := range strings.Split(val, ",") {
+		i, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a list of integers", val)
+		}
+		ints = append(ints, i)
+	}
+	return ints, nil
+}
+
+// parseFloats reads a comma separated list of numbers
+func parseFloats(val string) ([]float64, error) {
+	floats := []float64{}
+	for _, s := range strings.Split(val, ",") {
+		f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a list of numbers", val)
+		}
+		floats = append(floats, f)
+	}
+	return floats, nil
+}
+
+// tune sweeps the matcher parameters over labeled pairs, "reconcile tune"
+func tune(args []string) {
+	var (
+		labelsFName  string
+		complete     bool
+		outFName     string
+		curveFName   string
+		thresholds   string
+		distances    string
+		similarities string
+		weights      string
+	)
+	fs := flag.NewFlagSet("tune", flag.ExitOnError)
+	exports := addExportFlags(fs)
+	fs.StringVar(&labelsFName, "labels", "", "labeled pairs, a CSV file of oclc,tind,label where label is match or nonmatch")
+	fs.BoolVar(&complete, "complete", false, "the labels list every true match of the OCLC records they name, unlabeled predictions are false positives")
+	fs.StringVar(&outFName, "o", "matcher.json", "write the best config to this file, for -config")
+	fs.StringVar(&curveFName, "curve", "", "write the precision/recall of every config tried to this CSV file")
+	fs.StringVar(&thresholds, "thresholds", "3,4,5,6,7", "field agreement thresholds to try")
+	fs.StringVar(&distances, "distances", "0,1,2,3", "Levenshtein distances to try")
+	fs.StringVar(&similarities, "similarities", "0,0.8,0.85,0.9,0.95", "title similarity cutoffs to try, 0 for none")
+	fs.StringVar(&weights, "weights", "0,1,2", "field weights to try")
+	logOpts := addLogFlags(fs)
+	fs.Parse(args)
+	if err := logOpts.setup(); err != nil {
+		log.Fatal(err)
+	}
+	if labelsFName == "" {
+		log.Fatal("-labels is required")
+	}
+	opts := new(TuneOptions)
+	var err error
+	if opts.Thresholds, err = parseInts(thresholds); err != nil {
+		log.Fatalf("-thresholds, %s", err)
+	}
+	if opts.Distances, err = parseInts(distances); err != nil {
+		log.Fatalf("-distances, %s", err)
+	}
+	if opts.Similarities, err = parseFloats(similarities); err != nil {
+		log.Fatalf("-similarities, %s", err)
+	}
+	if opts.Weights, err = parseInts(weights); err != nil {
+		log.Fatalf("-weights, %s", err)
+	}
+
+	startT := time.Now()
+	labels, err := LoadLabels(labelsFName)
+	if err != nil {
+		log.Fatalf("Can't read %s, %s", labelsFName, err)
+	}
+	oclc, tind, err := exports.load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx, stop := interruptible()
+	defer stop()
+	best, points, err := Tune(ctx, opts, labels, oclc, tind, complete)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ev := best.Evaluation
+	slog.Info("tuned", "configs", len(points), "matcher", best.Config.String(), "precision", ev.Precision, "recall", ev.Recall, "f1", ev.F1, runningTime(startT))
+	// The first point tried is the built in matcher
+	base := points[0].Evaluation
+	slog.Info("built in matcher", "precision", base.Precision, "recall", base.Recall, "f1", base.F1)
+	if err := best.Config.Save(outFName); err != nil {
+		log.Fatalf("Can't write %s, %s", outFName, err)
+	}
+	if curveFName != "" {
+		if err := WriteCurve(curveFName, points); err != nil {
+			log.Fatalf("Can't write %s, %s", curveFName, err)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// tuneRecords returns n OCLC records, a TIND copy of most of them with
+// the odd title typo or field changed, and labels naming the copies
+func tuneRecords(n int, seed int64) ([]*Record, []*Record, []*Label) {
+	rnd := rand.New(rand.NewSource(seed))
+	words := strings.Fields("light nature history of the an introduction to physics modern art city river war peace " +
+		"letters journal early american california science theory practice survey new collected works")
+	oclc, tind, labels := []*Record{}, []*Record{}, []*Label{}
+	for i := 0; i < n; i++ {
+		title := []string{}
+		for j := 2 + rnd.Intn(4); j > 0; j-- {
+			title = append(title, words[rnd.Intn(len(words))])
+		}
+		target := &Record{MaterialType: "a", MonoOrSerial: "m", Form: "o",
+			OCLC:      fmt.Sprintf("%d", 1000+i),
+			ISBN:      fmt.Sprintf("978%010d", rnd.Intn(1000)),
+			Title:     strings.Join(title, " "),
+			Publisher: []string{"Addison-Wesley", "Knopf", "Norton"}[rnd.Intn(3)],
+			Year:      fmt.Sprintf("%d", 1950+rnd.Intn(50)),
+		}
+		target.Date1 = target.Year
+		oclc = append(oclc, target)
+		if rnd.Float64() > 0.8 {
+			continue
+		}
+		source := *target
+		source.OCLC, source.Tind = "", fmt.Sprintf("%d", 2000+i)
+		if rnd.Float64() < 0.3 {
+			k := rnd.Intn(len(source.Title))
+			source.Title = source.Title[:k] + source.Title[k+1:]
+		}
+		if rnd.Float64() < 0.2 {
+			source.Publisher = strings.ToUpper(source.Publisher)
+		}
+		tind = append(tind, &source)
+		labels = append(labels, &Label{OCLC: target.OCLC, Tind: source.Tind, Match: true})
+	}
+	return oclc, tind, labels
+}
+
+// TestTunerPredict checks the pairs newTuner keeps are enough for each
+// config swept to predict what predict does over every source
+func TestTunerPredict(t *testing.T) {
+	oclc, tind, labels := tuneRecords(150, 7)
+	es := newEvalSet(labels, oclc, tind, true)
+	opts := &TuneOptions{Thresholds: []int{3, 6}, Distances: []int{0, 2}, Similarities: []float64{0, 0.85}, Weights: []int{0, 2}}
+	tu, err := newTuner(context.Background(), es, tind, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept := 0
+	for _, pairs := range tu.pairs {
+		kept += len(pairs)
+	}
+	if kept >= len(es.targets)*len(tind)/10 {
+		t.Errorf("tuner kept %d of %d pairs", kept, len(es.targets)*len(tind))
+	}
+	configs := []*MatchConfig{DefaultMatchConfig()}
+	for _, threshold := range opts.Thresholds {
+		for _, d := range opts.Distances {
+			for _, cutoff := range opts.Similarities {
+				mc := DefaultMatchConfig()
+				mc.Threshold, mc.Levenshtein, mc.Similarity = threshold, d, cutoff
+				configs = append(configs, mc)
+			}
+		}
+	}
+	weighted := DefaultMatchConfig()
+	weighted.Threshold, weighted.Weights = 3, map[string]int{"isbn": 2, "publisher": 0}
+	configs = append(configs, weighted)
+	for _, mc := range configs {
+		want, err := predict(context.Background(), mc, es.targets, tind)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := tu.predict(mc)
+		if len(got) != len(want) {
+			t.Errorf("%s: tuner predicts %d pairs, predict %d", mc, len(got), len(want))
+		}
+		for key, p := range want {
+			if q, ok := got[key]; ok == false || q.c.Pass != p.c.Pass || q.c.Score != p.c.Score {
+				t.Errorf("%s: tuner missed or differs on %s/%s", mc, p.oclcID, p.c.source.Tind)
+			}
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUnmatchedTind(t *testing.T) {
+	dName := t.TempDir()
+	os.Mkdir(filepath.Join(dName, "data"), 0775)
+	files := map[string]string{
+		"matched-ids.csv": "101\n",
+		"data/rerun-oclc-all.csv": "material type,mono or serial,date1,date2,form,isbn,issn,oclc,title,subtitle,author,publisher,year,pagination\n" +
+			"a,m,1985,,o,1,,101,First,,,P,1985,\n" +
+			"a,m,1990,,o,2,,102,Second,,,P,1990,\n",
+		"data/rerun-tind-all.csv": "material type,mono or serial,date1,date2,form,tind,oclc,isbn,issn,title,subtitle,author,publisher,year,pagination\n" +
+			"a,m,1985,,o,2001,,1,,First,,,P,1985,\n" +
+			"a,m,1990,,o,2002,,2,,Second,,,P,1990,\n" +
+			"a,m,2000,,o,2003,,3,,Third,,,P,2000,\n",
+	}
+	for fName, src := range files {
+		if err := ioutil.WriteFile(filepath.Join(dName, fName), []byte(src), 0664); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cwd, _ := os.Getwd()
+	savedArgs, savedCommandLine, savedStdout := os.Args, flag.CommandLine, os.Stdout
+	defer func() {
+		os.Args, flag.CommandLine, os.Stdout = savedArgs, savedCommandLine, savedStdout
+		os.Chdir(cwd)
+	}()
+	os.Chdir(dName)
+	os.Args = []string{"reconcile2", "-unmatched-tind", "unmatched-tind.csv"}
+	flag.CommandLine = flag.NewFlagSet("reconcile2", flag.ExitOnError)
+	os.Stdout, _ = os.Open(os.DevNull)
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+	main()
+
+	src, err := ioutil.ReadFile(filepath.Join(dName, "unmatched-tind.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	//NOTE: 2001 is matched by 101, an OCLC number matched-ids.csv skips
+	lines := strings.Split(strings.TrimSpace(string(src)), "\n")
+	if len(lines) != 2 || strings.Contains(lines[1], `"2003"`) == false {
+		t.Errorf("unmatched TIND records are\n%s\nwant only 2003", src)
+	}
+}
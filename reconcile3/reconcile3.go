@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"encoding/csv"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -11,6 +12,7 @@ import (
 	"time"
 	// Caltech Library Packages
 	"github.com/caltechlibrary/datatools"
+	"github.com/caltechlibrary/oclc_reconciliation/unmatched"
 )
 
 type Record struct {
@@ -184,6 +186,8 @@ func Scan(target *Record, sources []*Record, withLevenshtein bool) string {
 
 func main() {
 	var (
+		unmatchedTindFName string
+
 		oclcColumns = []string{
 			"material type", // 0
 			"mono or serial",
@@ -228,28 +232,31 @@ func main() {
 		return "0%"
 	}
 
+	flag.StringVar(&unmatchedTindFName, "unmatched-tind", "", "write the TIND records no OCLC record matched to this CSV file")
+	flag.Parse()
+
 	startT := time.Now()
 	oclcSrc, err := ioutil.ReadFile("data/rerun-oclc-all.csv")
 	if err != nil {
-		log.Fatal("Can't read data/rerun-oclc-all.csv, %s", err)
+		log.Fatalf("Can't read data/rerun-oclc-all.csv, %s", err)
 	}
 	log.Printf("Read in data/rerun-oclc-all.csv, running time %s", time.Now().Sub(startT))
 	tindSrc, err := ioutil.ReadFile("data/rerun-tind-all.csv")
 	if err != nil {
-		log.Fatal("Can't read data/rerun-tind-all.csv, %s", err)
+		log.Fatalf("Can't read data/rerun-tind-all.csv, %s", err)
 	}
 	log.Printf("Read in data/rerun-tind-all.csv, running time %s", time.Now().Sub(startT))
 
 	oclc, err := mkRecords(oclcSrc, oclcColumns)
 	if err != nil {
-		log.Fatal("Can't decode oclc CSV, %s", err)
+		log.Fatalf("Can't decode oclc CSV, %s", err)
 	}
 	oclcCnt := len(oclc)
 	log.Printf("oclc rows: %d, running time %s", oclcCnt, time.Now().Sub(startT))
 
 	tind, err := mkRecords(tindSrc, tindColumns)
 	if err != nil {
-		log.Fatal("Can't decode tind CSV, %s", err)
+		log.Fatalf("Can't decode tind CSV, %s", err)
 	}
 	log.Printf("tind rows: %d, running time %s", len(tind), time.Now().Sub(startT))
 	filterT := time.Now()
@@ -276,5 +283,16 @@ func main() {
 			filterT = t
 		}
 	}
+	if unmatchedTindFName != "" {
+		log.Printf("Generating unmatched TIND list (%s), running time %s", unmatchedTindFName, time.Now().Sub(startT))
+		//NOTE: Scan stamps MatchedCount on each TIND record it matches
+		unmatchedTindCnt, err := unmatched.WriteCSV(unmatchedTindFName, new(Record).Header(), tind, func(rec *Record) bool {
+			return rec.MatchedCount > 0
+		})
+		if err != nil {
+			log.Fatalf("Can't write %s, %s", unmatchedTindFName, err)
+		}
+		log.Printf("%d/%d TIND rows unmatched", unmatchedTindCnt, len(tind))
+	}
 	log.Printf("Running time %s", time.Now().Sub(startT))
 }
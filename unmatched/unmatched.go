@@ -0,0 +1,30 @@
+// Package unmatched writes the records a reconciliation left without a
+// match, the list reconcile2 and reconcile3 write with -unmatched-tind
+package unmatched
+
+import (
+	"fmt"
+	"os"
+)
+
+// WriteCSV writes header and the records matched reports false for to
+// fName, each record's String being its CSV row. Returns the number of
+// records written.
+func WriteCSV[R fmt.Stringer](fName, header string, records []R, matched func(R) bool) (int, error) {
+	fp, err := os.Create(fName)
+	if err != nil {
+		return 0, err
+	}
+	defer fp.Close()
+	cnt := 0
+	fmt.Fprintln(fp, header)
+	for _, rec := range records {
+		if matched(rec) == false {
+			if _, err := fmt.Fprintf(fp, "%s\n", rec.String()); err != nil {
+				return cnt, err
+			}
+			cnt++
+		}
+	}
+	return cnt, fp.Close()
+}
@@ -0,0 +1,30 @@
+package unmatched
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+type row struct {
+	id      string
+	matched bool
+}
+
+func (r *row) String() string { return r.id }
+
+func TestWriteCSV(t *testing.T) {
+	fName := filepath.Join(t.TempDir(), "unmatched.csv")
+	rows := []*row{{"2001", true}, {"2002", false}, {"2003", true}, {"2004", false}}
+	cnt, err := WriteCSV(fName, "id", rows, func(r *row) bool { return r.matched })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cnt != 2 {
+		t.Errorf("wrote %d records, want 2", cnt)
+	}
+	src, _ := ioutil.ReadFile(fName)
+	if string(src) != "id\n2002\n2004\n" {
+		t.Errorf("wrote %q", src)
+	}
+}